@@ -0,0 +1,302 @@
+package mutatingwebhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	cdicorev1alpha1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+const (
+	admissionV1      = "admission.k8s.io/v1"
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+
+	// AnnOwnedByController marks a DataVolume as having already received its controller
+	// owner-reference/finalizer defaults from this webhook, so they aren't re-applied on update.
+	AnnOwnedByController = "cdi.kubevirt.io/storage.ownedByController"
+
+	// cdiFinalizer is stamped onto every DataVolume this webhook defaults, so the controller can
+	// run cleanup before the object is removed.
+	cdiFinalizer = "cdi.kubevirt.io/dataVolumeFinalizer"
+)
+
+var defaultStorageClassName string
+
+// SetDefaultStorageClassName configures the storageClassName MutateDVs injects into a
+// DataVolume's PVC spec when the user left it unset.
+func SetDefaultStorageClassName(name string) {
+	defaultStorageClassName = name
+}
+
+// admissionRequest is a version-agnostic view of the AdmissionRequest fields MutateDVs needs,
+// regardless of whether the caller sent an admission.k8s.io/v1 or admission.k8s.io/v1beta1
+// AdmissionReview.
+type admissionRequest struct {
+	UID      ktypes.UID
+	Resource metav1.GroupVersionResource
+	Object   runtime.RawExtension
+}
+
+// admissionResponse is the version-agnostic counterpart of admissionRequest, re-encoded by serve
+// into whichever AdmissionReview version the request used.
+type admissionResponse struct {
+	Allowed bool
+	Patch   []byte
+	Result  *metav1.Status
+}
+
+type admitFunc func(*admissionRequest) *admissionResponse
+
+// patchOperation is a single RFC 6902 JSON Patch operation.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func toAdmissionRequest(r *http.Request) (*admissionRequest, string, error) {
+	var body []byte
+	if r.Body != nil {
+		if data, err := ioutil.ReadAll(r.Body); err == nil {
+			body = data
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		return nil, "", fmt.Errorf("contentType=%s, expect application/json", contentType)
+	}
+
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		return nil, "", err
+	}
+
+	switch typeMeta.APIVersion {
+	case admissionV1:
+		ar := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, "", err
+		}
+		if ar.Request == nil {
+			return &admissionRequest{}, admissionV1, nil
+		}
+		return &admissionRequest{
+			UID:      ar.Request.UID,
+			Resource: ar.Request.Resource,
+			Object:   ar.Request.Object,
+		}, admissionV1, nil
+	default:
+		ar := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, "", err
+		}
+		if ar.Request == nil {
+			return &admissionRequest{}, admissionV1beta1, nil
+		}
+		return &admissionRequest{
+			UID:      ar.Request.UID,
+			Resource: ar.Request.Resource,
+			Object:   ar.Request.Object,
+		}, admissionV1beta1, nil
+	}
+}
+
+func encodeAdmissionResponse(apiVersion string, uid ktypes.UID, reviewResponse *admissionResponse) ([]byte, error) {
+	switch apiVersion {
+	case admissionV1:
+		response := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: admissionV1, Kind: "AdmissionReview"},
+		}
+		if reviewResponse != nil {
+			response.Response = toV1Response(uid, reviewResponse)
+		}
+		return json.Marshal(response)
+	default:
+		response := v1beta1.AdmissionReview{}
+		if reviewResponse != nil {
+			response.Response = toV1beta1Response(uid, reviewResponse)
+		}
+		return json.Marshal(response)
+	}
+}
+
+func toV1Response(uid ktypes.UID, reviewResponse *admissionResponse) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: reviewResponse.Allowed,
+		Result:  reviewResponse.Result,
+	}
+	if len(reviewResponse.Patch) > 0 {
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = reviewResponse.Patch
+		response.PatchType = &patchType
+	}
+	return response
+}
+
+func toV1beta1Response(uid ktypes.UID, reviewResponse *admissionResponse) *v1beta1.AdmissionResponse {
+	response := &v1beta1.AdmissionResponse{
+		UID:     uid,
+		Allowed: reviewResponse.Allowed,
+		Result:  reviewResponse.Result,
+	}
+	if len(reviewResponse.Patch) > 0 {
+		patchType := v1beta1.PatchTypeJSONPatch
+		response.Patch = reviewResponse.Patch
+		response.PatchType = &patchType
+	}
+	return response
+}
+
+func toAdmissionResponseError(err error) *admissionResponse {
+	return &admissionResponse{
+		Result: &metav1.Status{
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		},
+	}
+}
+
+// MutateDVs defaults unset DataVolume fields: contentType, the cluster-default
+// storageClassName, a single ReadWriteOnce access mode, and the controller's
+// owner-reference/finalizer annotations. It returns a JSONPatch AdmissionResponse containing
+// only the fields that needed defaulting.
+func MutateDVs(ar *admissionRequest) *admissionResponse {
+	resource := metav1.GroupVersionResource{
+		Group:    cdicorev1alpha1.SchemeGroupVersion.Group,
+		Version:  cdicorev1alpha1.SchemeGroupVersion.Version,
+		Resource: "datavolumes",
+	}
+	if ar.Resource != resource {
+		klog.Errorf("resource is %s but request is: %s", resource, ar.Resource)
+		err := fmt.Errorf("expect resource to be '%s'", resource.Resource)
+		return toAdmissionResponseError(err)
+	}
+
+	dv := cdicorev1alpha1.DataVolume{}
+	if err := json.Unmarshal(ar.Object.Raw, &dv); err != nil {
+		return toAdmissionResponseError(err)
+	}
+
+	var patches []patchOperation
+
+	if dv.Spec.ContentType == "" {
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/contentType",
+			Value: cdicorev1alpha1.DataVolumeKubeVirt,
+		})
+	}
+
+	if dv.Spec.PVC != nil {
+		if dv.Spec.PVC.StorageClassName == nil && defaultStorageClassName != "" {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/spec/pvc/storageClassName",
+				Value: defaultStorageClassName,
+			})
+		}
+		if len(dv.Spec.PVC.AccessModes) == 0 {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/spec/pvc/accessModes",
+				Value: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			})
+		}
+	}
+
+	if _, ok := dv.Annotations[AnnOwnedByController]; !ok {
+		if dv.Annotations == nil {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations",
+				Value: map[string]string{AnnOwnedByController: "true"},
+			})
+		} else {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations/" + jsonPatchEscape(AnnOwnedByController),
+				Value: "true",
+			})
+		}
+		if len(dv.Finalizers) == 0 {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/metadata/finalizers",
+				Value: []string{cdiFinalizer},
+			})
+		} else {
+			patches = append(patches, patchOperation{
+				Op:    "add",
+				Path:  "/metadata/finalizers/-",
+				Value: cdiFinalizer,
+			})
+		}
+	}
+
+	if len(patches) == 0 {
+		return &admissionResponse{Allowed: true}
+	}
+
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		return toAdmissionResponseError(err)
+	}
+	return &admissionResponse{Allowed: true, Patch: patchBytes}
+}
+
+// jsonPatchEscape escapes "~" and "/" per RFC 6901 so an annotation key can be used as a JSON
+// Patch path segment.
+func jsonPatchEscape(s string) string {
+	escaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '~':
+			escaped = append(escaped, '~', '0')
+		case '/':
+			escaped = append(escaped, '~', '1')
+		default:
+			escaped = append(escaped, s[i])
+		}
+	}
+	return string(escaped)
+}
+
+func serve(resp http.ResponseWriter, req *http.Request, admit admitFunc) {
+	review, apiVersion, err := toAdmissionRequest(req)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	reviewResponse := admit(review)
+
+	responseBytes, err := encodeAdmissionResponse(apiVersion, review.UID, reviewResponse)
+	if err != nil {
+		klog.Errorf("failed json encode webhook response: %s", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err := resp.Write(responseBytes); err != nil {
+		klog.Errorf("failed to write webhook response: %s", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp.WriteHeader(http.StatusOK)
+}
+
+// ServeDVs ..
+func ServeDVs(resp http.ResponseWriter, req *http.Request) {
+	serve(resp, req, MutateDVs)
+}
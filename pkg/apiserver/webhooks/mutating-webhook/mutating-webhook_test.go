@@ -0,0 +1,145 @@
+package mutatingwebhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	cdicorev1alpha1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func dvResource() metav1.GroupVersionResource {
+	return metav1.GroupVersionResource{
+		Group:    cdicorev1alpha1.SchemeGroupVersion.Group,
+		Version:  cdicorev1alpha1.SchemeGroupVersion.Version,
+		Resource: "datavolumes",
+	}
+}
+
+func newAdmissionRequestForDV(t *testing.T, dv *cdicorev1alpha1.DataVolume) *admissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(dv)
+	if err != nil {
+		t.Fatalf("unable to marshal DataVolume: %v", err)
+	}
+	return &admissionRequest{
+		Resource: dvResource(),
+		Object:   runtime.RawExtension{Raw: raw},
+	}
+}
+
+func patchOps(t *testing.T, resp *admissionResponse) []patchOperation {
+	t.Helper()
+	if len(resp.Patch) == 0 {
+		return nil
+	}
+	var ops []patchOperation
+	if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+		t.Fatalf("unable to unmarshal patch: %v", err)
+	}
+	return ops
+}
+
+func hasOp(ops []patchOperation, path string) bool {
+	for _, op := range ops {
+		if op.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMutateDVsDefaultsEmptySpec(t *testing.T) {
+	SetDefaultStorageClassName("default-sc")
+	defer SetDefaultStorageClassName("")
+
+	dv := &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "dv1"},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			PVC: &v1.PersistentVolumeClaimSpec{},
+		},
+	}
+	resp := MutateDVs(newAdmissionRequestForDV(t, dv))
+	if !resp.Allowed {
+		t.Fatalf("expected admission to be allowed, got %+v", resp.Result)
+	}
+	ops := patchOps(t, resp)
+	for _, path := range []string{"/spec/contentType", "/spec/pvc/storageClassName", "/spec/pvc/accessModes"} {
+		if !hasOp(ops, path) {
+			t.Errorf("expected a patch operation for %s, got %+v", path, ops)
+		}
+	}
+}
+
+func TestMutateDVsLeavesExplicitFieldsAlone(t *testing.T) {
+	sc := "explicit-sc"
+	dv := &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns1",
+			Name:        "dv1",
+			Annotations: map[string]string{AnnOwnedByController: "true"},
+		},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			ContentType: cdicorev1alpha1.DataVolumeArchive,
+			PVC: &v1.PersistentVolumeClaimSpec{
+				StorageClassName: &sc,
+				AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+			},
+		},
+	}
+	resp := MutateDVs(newAdmissionRequestForDV(t, dv))
+	if !resp.Allowed {
+		t.Fatalf("expected admission to be allowed, got %+v", resp.Result)
+	}
+	if len(resp.Patch) != 0 {
+		t.Errorf("expected no patch when every field is already set, got %s", resp.Patch)
+	}
+}
+
+func TestMutateDVsStampsOwnerAnnotationAndFinalizer(t *testing.T) {
+	dv := &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "dv1"},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			ContentType: cdicorev1alpha1.DataVolumeKubeVirt,
+		},
+	}
+	resp := MutateDVs(newAdmissionRequestForDV(t, dv))
+	ops := patchOps(t, resp)
+	if !hasOp(ops, "/metadata/annotations") {
+		t.Errorf("expected an annotations patch, got %+v", ops)
+	}
+	if !hasOp(ops, "/metadata/finalizers") {
+		t.Errorf("expected a whole-array finalizers patch for a DataVolume with no finalizers yet, got %+v", ops)
+	}
+}
+
+func TestMutateDVsAppendsFinalizerWhenArrayAlreadyExists(t *testing.T) {
+	dv := &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  "ns1",
+			Name:       "dv1",
+			Finalizers: []string{"some.other/finalizer"},
+		},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			ContentType: cdicorev1alpha1.DataVolumeKubeVirt,
+		},
+	}
+	resp := MutateDVs(newAdmissionRequestForDV(t, dv))
+	ops := patchOps(t, resp)
+	if !hasOp(ops, "/metadata/finalizers/-") {
+		t.Errorf("expected an append-style finalizer patch when finalizers already exist, got %+v", ops)
+	}
+	if hasOp(ops, "/metadata/finalizers") {
+		t.Errorf("did not expect a whole-array finalizers patch when finalizers already exist, got %+v", ops)
+	}
+}
+
+func TestMutateDVsRejectsWrongResource(t *testing.T) {
+	resp := MutateDVs(&admissionRequest{Resource: metav1.GroupVersionResource{Resource: "pods"}})
+	if resp.Allowed {
+		t.Error("expected admission to be rejected for a non-datavolume resource")
+	}
+}
@@ -1,26 +1,67 @@
 package validatingwebhook
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
+	authnv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
 	k8sfield "k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
 	"k8s.io/klog"
+
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/pkg/client/clientset/versioned"
+
 	cdicorev1alpha1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 	"kubevirt.io/containerized-data-importer/pkg/controller"
 )
 
-type admitFunc func(*v1beta1.AdmissionReview) *v1beta1.AdmissionResponse
+const (
+	admissionV1      = "admission.k8s.io/v1"
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+)
+
+// admissionRequest is a version-agnostic view of the AdmissionRequest fields admitDVs needs,
+// regardless of whether the caller sent an admission.k8s.io/v1 or admission.k8s.io/v1beta1
+// AdmissionReview. v1beta1 was removed in Kubernetes 1.22, so both have to be supported.
+type admissionRequest struct {
+	UID      ktypes.UID
+	Resource metav1.GroupVersionResource
+	Object   runtime.RawExtension
+	UserInfo authnv1.UserInfo
+}
 
-func toAdmissionReview(r *http.Request) (*v1beta1.AdmissionReview, error) {
+// admissionResponse is the version-agnostic counterpart of admissionRequest, re-encoded by serve
+// into whichever AdmissionReview version the request used.
+type admissionResponse struct {
+	Allowed bool
+	Result  *metav1.Status
+}
+
+type admitFunc func(*admissionRequest) *admissionResponse
+
+// toAdmissionRequest reads the AdmissionReview body, negotiating on its apiVersion, and returns
+// a version-agnostic admissionRequest plus the apiVersion the response should be encoded as.
+func toAdmissionRequest(r *http.Request) (*admissionRequest, string, error) {
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -31,21 +72,82 @@ func toAdmissionReview(r *http.Request) (*v1beta1.AdmissionReview, error) {
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
-		return nil, fmt.Errorf("contentType=%s, expect application/json", contentType)
+		return nil, "", fmt.Errorf("contentType=%s, expect application/json", contentType)
 	}
 
-	ar := &v1beta1.AdmissionReview{}
-	err := json.Unmarshal(body, ar)
-	return ar, err
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		return nil, "", err
+	}
+
+	switch typeMeta.APIVersion {
+	case admissionV1:
+		ar := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, "", err
+		}
+		if ar.Request == nil {
+			return &admissionRequest{}, admissionV1, nil
+		}
+		return &admissionRequest{
+			UID:      ar.Request.UID,
+			Resource: ar.Request.Resource,
+			Object:   ar.Request.Object,
+			UserInfo: ar.Request.UserInfo,
+		}, admissionV1, nil
+	default:
+		ar := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, "", err
+		}
+		if ar.Request == nil {
+			return &admissionRequest{}, admissionV1beta1, nil
+		}
+		return &admissionRequest{
+			UID:      ar.Request.UID,
+			Resource: ar.Request.Resource,
+			Object:   ar.Request.Object,
+			UserInfo: ar.Request.UserInfo,
+		}, admissionV1beta1, nil
+	}
+}
+
+// encodeAdmissionResponse marshals reviewResponse as the Response of an AdmissionReview of the
+// given apiVersion, matching whichever version the incoming request used.
+func encodeAdmissionResponse(apiVersion string, uid ktypes.UID, reviewResponse *admissionResponse) ([]byte, error) {
+	switch apiVersion {
+	case admissionV1:
+		response := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: admissionV1, Kind: "AdmissionReview"},
+		}
+		if reviewResponse != nil {
+			response.Response = &admissionv1.AdmissionResponse{
+				UID:     uid,
+				Allowed: reviewResponse.Allowed,
+				Result:  reviewResponse.Result,
+			}
+		}
+		return json.Marshal(response)
+	default:
+		response := v1beta1.AdmissionReview{}
+		if reviewResponse != nil {
+			response.Response = &v1beta1.AdmissionResponse{
+				UID:     uid,
+				Allowed: reviewResponse.Allowed,
+				Result:  reviewResponse.Result,
+			}
+		}
+		return json.Marshal(response)
+	}
 }
 
-func toRejectedAdmissionResponse(causes []metav1.StatusCause) *v1beta1.AdmissionResponse {
+func toRejectedAdmissionResponse(causes []metav1.StatusCause) *admissionResponse {
 	globalMessage := ""
 	for _, cause := range causes {
 		globalMessage = fmt.Sprintf("%s %s", globalMessage, cause.Message)
 	}
 
-	return &v1beta1.AdmissionResponse{
+	return &admissionResponse{
 		Result: &metav1.Status{
 			Message: globalMessage,
 			Code:    http.StatusUnprocessableEntity,
@@ -56,8 +158,8 @@ func toRejectedAdmissionResponse(causes []metav1.StatusCause) *v1beta1.Admission
 	}
 }
 
-func toAdmissionResponseError(err error) *v1beta1.AdmissionResponse {
-	return &v1beta1.AdmissionResponse{
+func toAdmissionResponseError(err error) *admissionResponse {
+	return &admissionResponse{
 		Result: &metav1.Status{
 			Message: err.Error(),
 			Code:    http.StatusBadRequest,
@@ -79,13 +181,101 @@ func validateSourceURL(sourceURL string) string {
 	return ""
 }
 
-func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolumeSpec) []metav1.StatusCause {
+// AnnSourceProbeSkip lets air-gapped clusters, which have no route to the source URL, skip the
+// admission-time reachability probe below.
+const AnnSourceProbeSkip = "cdi.kubevirt.io/storage.skipSourceProbe"
+
+var sourceProbeTimeout = 5 * time.Second
+
+// SetSourceProbeTimeout configures how long validateDataVolumeSpec waits for a response when
+// probing an HTTP/S3 source URL during admission.
+func SetSourceProbeTimeout(timeout time.Duration) {
+	sourceProbeTimeout = timeout
+}
+
+// probeSourceURL issues a HEAD request against rawURL to catch an unreachable or misconfigured
+// source before a DataVolume is admitted, rather than failing deep inside the importer pod.
+// secretRef, if set, is looked up in namespace and used for HTTP basic auth; certConfigMap, if
+// set, provides a custom CA bundle for TLS verification. useRangedGet issues a ranged GET
+// (Range: bytes=0-0) instead of a HEAD: presigned S3 URLs sign the HTTP method into the
+// signature, so a HEAD request against a GET-signed URL is rejected with 403 by servers that
+// verify signatures strictly. It returns the reported source size (0 if unknown) and an empty
+// message on success, or a non-empty message describing the failure.
+func probeSourceURL(client kubernetes.Interface, namespace, rawURL, secretRef, certConfigMap string, useRangedGet bool) (int64, string) {
+	transport := &http.Transport{}
+	if certConfigMap != "" {
+		cm, err := client.CoreV1().ConfigMaps(namespace).Get(certConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return 0, fmt.Sprintf("unable to read CertConfigMap %s/%s: %v", namespace, certConfigMap, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cm.Data["ca.pem"])) {
+			return 0, fmt.Sprintf("CertConfigMap %s/%s does not contain a valid CA bundle", namespace, certConfigMap)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	method := http.MethodHead
+	if useRangedGet {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return 0, fmt.Sprintf("invalid source URL: %v", err)
+	}
+	if useRangedGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	if secretRef != "" {
+		secret, err := client.CoreV1().Secrets(namespace).Get(secretRef, metav1.GetOptions{})
+		if err != nil {
+			return 0, fmt.Sprintf("unable to read SecretRef %s/%s: %v", namespace, secretRef, err)
+		}
+		req.SetBasicAuth(string(secret.Data["accessKeyId"]), string(secret.Data["secretKey"]))
+	}
+
+	httpClient := &http.Client{Timeout: sourceProbeTimeout, Transport: transport}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Sprintf("source URL is not reachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Sprintf("source URL returned HTTP status %d", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		// A 206's Content-Length is the length of the partial body, not the source size.
+		total, _ := parseContentRangeTotal(resp.Header.Get("Content-Range"))
+		return total, ""
+	}
+	if resp.ContentLength < 0 {
+		return 0, ""
+	}
+	return resp.ContentLength, ""
+}
+
+// parseContentRangeTotal extracts the total resource size from a "bytes 0-0/12345" Content-Range
+// header, as returned by a ranged GET. It returns false if the header is missing the total (e.g.
+// "bytes 0-0/*") or is otherwise malformed.
+func parseContentRangeTotal(contentRange string) (int64, bool) {
+	slash := strings.LastIndex(contentRange, "/")
+	if slash == -1 {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+func validateDataVolumeSpec(field *k8sfield.Path, namespace string, annotations map[string]string, spec *cdicorev1alpha1.DataVolumeSpec) []metav1.StatusCause {
 	var causes []metav1.StatusCause
 	var url string
 	var sourceType string
 	// spec source field should not be empty
 	if &spec.Source == nil || (spec.Source.HTTP == nil && spec.Source.S3 == nil && spec.Source.PVC == nil && spec.Source.Upload == nil &&
-		spec.Source.Blank == nil && spec.Source.Registry == nil) {
+		spec.Source.Blank == nil && spec.Source.Registry == nil && spec.Source.Snapshot == nil) {
 		causes = append(causes, metav1.StatusCause{
 			Type:    metav1.CauseTypeFieldValueInvalid,
 			Message: fmt.Sprintf("Missing Data volume source"),
@@ -94,11 +284,12 @@ func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolu
 		return causes
 	}
 
-	if (spec.Source.HTTP != nil && (spec.Source.S3 != nil || spec.Source.PVC != nil || spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil)) ||
-		(spec.Source.S3 != nil && (spec.Source.PVC != nil || spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil)) ||
-		(spec.Source.PVC != nil && (spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil)) ||
-		(spec.Source.Upload != nil && (spec.Source.Blank != nil || spec.Source.Registry != nil)) ||
-		(spec.Source.Blank != nil && spec.Source.Registry != nil) {
+	if (spec.Source.HTTP != nil && (spec.Source.S3 != nil || spec.Source.PVC != nil || spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil || spec.Source.Snapshot != nil)) ||
+		(spec.Source.S3 != nil && (spec.Source.PVC != nil || spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil || spec.Source.Snapshot != nil)) ||
+		(spec.Source.PVC != nil && (spec.Source.Upload != nil || spec.Source.Blank != nil || spec.Source.Registry != nil || spec.Source.Snapshot != nil)) ||
+		(spec.Source.Upload != nil && (spec.Source.Blank != nil || spec.Source.Registry != nil || spec.Source.Snapshot != nil)) ||
+		(spec.Source.Blank != nil && (spec.Source.Registry != nil || spec.Source.Snapshot != nil)) ||
+		(spec.Source.Registry != nil && spec.Source.Snapshot != nil) {
 		causes = append(causes, metav1.StatusCause{
 			Type:    metav1.CauseTypeFieldValueInvalid,
 			Message: fmt.Sprintf("Multiple Data volume sources"),
@@ -108,11 +299,15 @@ func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolu
 	}
 	// if source types are HTTP or S3, check if URL is valid
 	if spec.Source.HTTP != nil || spec.Source.S3 != nil {
+		var secretRef, certConfigMap string
 		if spec.Source.HTTP != nil {
 			url = spec.Source.HTTP.URL
+			secretRef = spec.Source.HTTP.SecretRef
+			certConfigMap = spec.Source.HTTP.CertConfigMap
 			sourceType = field.Child("source", "HTTP", "url").String()
 		} else if spec.Source.S3 != nil {
 			url = spec.Source.S3.URL
+			secretRef = spec.Source.S3.SecretRef
 			sourceType = field.Child("source", "S3", "url").String()
 		}
 		err := validateSourceURL(url)
@@ -124,6 +319,31 @@ func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolu
 			})
 			return causes
 		}
+
+		if annotations[AnnSourceProbeSkip] != "true" {
+			probeClient := GetClient()
+			if probeClient != nil {
+				contentLength, probeErr := probeSourceURL(probeClient, namespace, url, secretRef, certConfigMap, spec.Source.S3 != nil)
+				if probeErr != "" {
+					causes = append(causes, metav1.StatusCause{
+						Type:    metav1.CauseTypeFieldValueInvalid,
+						Message: fmt.Sprintf("%s %s", field.Child("source").String(), probeErr),
+						Field:   sourceType,
+					})
+					return causes
+				}
+				if contentLength > 0 && spec.PVC != nil {
+					if pvcSize, ok := spec.PVC.Resources.Requests["storage"]; ok && pvcSize.Value() < contentLength {
+						causes = append(causes, metav1.StatusCause{
+							Type:    metav1.CauseTypeFieldValueInvalid,
+							Message: fmt.Sprintf("PVC size is too small for source, source is %d bytes", contentLength),
+							Field:   field.Child("PVC", "resources", "requests", "size").String(),
+						})
+						return causes
+					}
+				}
+			}
+		}
 	}
 
 	// Make sure contentType is either empty (kubevirt), or kubevirt or archive
@@ -191,6 +411,56 @@ func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolu
 		}
 	}
 
+	if spec.Source.Snapshot != nil {
+		snapshotSource := spec.Source.Snapshot
+		if snapshotSource.Namespace == "" || snapshotSource.Name == "" {
+			causes = append(causes, metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldValueInvalid,
+				Message: fmt.Sprintf("%s source snapshot is not valid", field.Child("source", "Snapshot").String()),
+				Field:   field.Child("source", "Snapshot").String(),
+			})
+			return causes
+		}
+		snapshotClient := GetSnapshotClient()
+		if snapshotClient != nil {
+			snapshot, err := snapshotClient.SnapshotV1beta1().VolumeSnapshots(snapshotSource.Namespace).Get(snapshotSource.Name, metav1.GetOptions{})
+			if err != nil {
+				if k8serrors.IsNotFound(err) {
+					causes = append(causes, metav1.StatusCause{
+						Type:    metav1.CauseTypeFieldValueNotFound,
+						Message: fmt.Sprintf("Source snapshot %s/%s doesn't exist", snapshotSource.Namespace, snapshotSource.Name),
+						Field:   field.Child("source", "Snapshot").String(),
+					})
+					return causes
+				}
+				causes = append(causes, metav1.StatusCause{
+					Type:    metav1.CauseTypeFieldValueInvalid,
+					Message: fmt.Sprintf("Unable to look up source snapshot %s/%s: %v", snapshotSource.Namespace, snapshotSource.Name, err),
+					Field:   field.Child("source", "Snapshot").String(),
+				})
+				return causes
+			}
+			if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+				causes = append(causes, metav1.StatusCause{
+					Type:    metav1.CauseTypeFieldValueInvalid,
+					Message: fmt.Sprintf("Source snapshot %s/%s is not ready to use", snapshotSource.Namespace, snapshotSource.Name),
+					Field:   field.Child("source", "Snapshot").String(),
+				})
+				return causes
+			}
+			if spec.PVC != nil && snapshot.Status.RestoreSize != nil {
+				if pvcSize, ok := spec.PVC.Resources.Requests["storage"]; ok && pvcSize.Cmp(*snapshot.Status.RestoreSize) < 0 {
+					causes = append(causes, metav1.StatusCause{
+						Type:    metav1.CauseTypeFieldValueInvalid,
+						Message: fmt.Sprintf("Target PVC size %s is smaller than source snapshot restore size %s", pvcSize.String(), snapshot.Status.RestoreSize.String()),
+						Field:   field.Child("PVC", "resources", "requests", "size").String(),
+					})
+					return causes
+				}
+			}
+		}
+	}
+
 	if spec.PVC == nil {
 		causes = append(causes, metav1.StatusCause{
 			Type:    metav1.CauseTypeFieldValueInvalid,
@@ -238,19 +508,183 @@ func validateDataVolumeSpec(field *k8sfield.Path, spec *cdicorev1alpha1.DataVolu
 	return causes
 }
 
-func admitDVs(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+// validateSourcePVCAuthorization checks, for a DataVolume cloning from a PVC in another
+// namespace, that the user performing the request is allowed to get that source PVC. Without
+// this, anyone able to create a DataVolume in namespace A could read any PVC in namespace B by
+// cloning it.
+func validateSourcePVCAuthorization(client kubernetes.Interface, ar *admissionRequest, dv *cdicorev1alpha1.DataVolume) []metav1.StatusCause {
+	var causes []metav1.StatusCause
+	sourcePVC := dv.Spec.Source.PVC
+	if sourcePVC == nil || sourcePVC.Namespace == "" || sourcePVC.Namespace == dv.Namespace || client == nil {
+		return causes
+	}
+
+	sar := &authorizationv1.LocalSubjectAccessReview{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: sourcePVC.Namespace,
+		},
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   ar.UserInfo.Username,
+			Groups: ar.UserInfo.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: sourcePVC.Namespace,
+				Verb:      "get",
+				Group:     "",
+				Resource:  "persistentvolumeclaims",
+				Name:      sourcePVC.Name,
+			},
+		},
+	}
+
+	response, err := client.AuthorizationV1().LocalSubjectAccessReviews(sourcePVC.Namespace).Create(sar)
+	if err != nil {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueInvalid,
+			Message: fmt.Sprintf("unable to verify permission to clone from source PVC: %v", err),
+			Field:   k8sfield.NewPath("spec", "source", "PVC").String(),
+		})
+		return causes
+	}
+	if !response.Status.Allowed {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueForbidden,
+			Message: fmt.Sprintf("User '%s' has insufficient permissions in clone source namespace '%s'", ar.UserInfo.Username, sourcePVC.Namespace),
+			Field:   k8sfield.NewPath("spec", "source", "PVC").String(),
+		})
+	}
+	return causes
+}
+
+// validateSourceSnapshotAuthorization is validateSourcePVCAuthorization's counterpart for cloning
+// from a VolumeSnapshot in another namespace: the same information (snapshot existence,
+// readiness, restore size) is exposed by validateDataVolumeSpec, so it needs the same
+// authorization gate before that lookup ever runs.
+func validateSourceSnapshotAuthorization(client kubernetes.Interface, ar *admissionRequest, dv *cdicorev1alpha1.DataVolume) []metav1.StatusCause {
+	var causes []metav1.StatusCause
+	sourceSnapshot := dv.Spec.Source.Snapshot
+	if sourceSnapshot == nil || sourceSnapshot.Namespace == "" || sourceSnapshot.Namespace == dv.Namespace || client == nil {
+		return causes
+	}
+
+	sar := &authorizationv1.LocalSubjectAccessReview{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: sourceSnapshot.Namespace,
+		},
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   ar.UserInfo.Username,
+			Groups: ar.UserInfo.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: sourceSnapshot.Namespace,
+				Verb:      "get",
+				Group:     "snapshot.storage.k8s.io",
+				Resource:  "volumesnapshots",
+				Name:      sourceSnapshot.Name,
+			},
+		},
+	}
+
+	response, err := client.AuthorizationV1().LocalSubjectAccessReviews(sourceSnapshot.Namespace).Create(sar)
+	if err != nil {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueInvalid,
+			Message: fmt.Sprintf("unable to verify permission to clone from source snapshot: %v", err),
+			Field:   k8sfield.NewPath("spec", "source", "Snapshot").String(),
+		})
+		return causes
+	}
+	if !response.Status.Allowed {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueForbidden,
+			Message: fmt.Sprintf("User '%s' has insufficient permissions in clone source namespace '%s'", ar.UserInfo.Username, sourceSnapshot.Namespace),
+			Field:   k8sfield.NewPath("spec", "source", "Snapshot").String(),
+		})
+	}
+	return causes
+}
+
+var eventRecorder record.EventRecorder
+
+// SetRecorder sets the EventRecorder used to publish Kubernetes Events when a DataVolume
+// admission is rejected, giving admins a cluster-visible audit trail. Emitting events is
+// skipped when no recorder has been configured, so unit tests don't need a fake event client.
+func SetRecorder(recorder record.EventRecorder) {
+	eventRecorder = recorder
+}
+
+var kubeClient kubernetes.Interface
+
+// SetClient sets the Kubernetes client validateDataVolumeSpec uses to probe source URLs and look
+// up clone source PVCs, and admitDVs uses to run SubjectAccessReviews.
+func SetClient(client kubernetes.Interface) {
+	kubeClient = client
+}
+
+// GetClient returns the client configured via SetClient, or nil if none has been configured. The
+// lookups it guards are skipped entirely when it returns nil, so unit tests don't need a fake
+// client unless they're exercising that specific lookup.
+func GetClient() kubernetes.Interface {
+	return kubeClient
+}
+
+var snapshotClient snapshotclientset.Interface
+
+// SetSnapshotClient sets the VolumeSnapshot client validateDataVolumeSpec uses to look up clone
+// source Snapshots.
+func SetSnapshotClient(client snapshotclientset.Interface) {
+	snapshotClient = client
+}
+
+// GetSnapshotClient returns the client configured via SetSnapshotClient, or nil if none has been
+// configured. The Snapshot source lookup it guards is skipped entirely when it returns nil, so
+// unit tests don't need a fake snapshot client unless they're exercising that lookup.
+func GetSnapshotClient() snapshotclientset.Interface {
+	return snapshotClient
+}
+
+// NewRecorder builds an EventRecorder that publishes Events through client, identifying itself
+// as component.
+func NewRecorder(client kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
+
+// recordRejectionEvent emits a Warning event explaining why dv was rejected. If dv does not yet
+// have a UID (e.g. the admission request is a dry-run or the object reference can't be
+// constructed) the event is recorded against dv's namespace instead.
+func recordRejectionEvent(dv *cdicorev1alpha1.DataVolume, causes []metav1.StatusCause) {
+	if eventRecorder == nil {
+		return
+	}
+	message := ""
+	for _, cause := range causes {
+		message = fmt.Sprintf("%s %s", message, cause.Message)
+	}
+
+	ref, err := reference.GetReference(scheme.Scheme, dv)
+	if err != nil || dv.GetUID() == "" {
+		ref = &v1.ObjectReference{
+			Kind:      "Namespace",
+			Name:      dv.GetNamespace(),
+			Namespace: dv.GetNamespace(),
+		}
+	}
+	eventRecorder.Event(ref, v1.EventTypeWarning, "DataVolumeRejected", message)
+}
+
+func admitDVs(ar *admissionRequest) *admissionResponse {
 	resource := metav1.GroupVersionResource{
 		Group:    cdicorev1alpha1.SchemeGroupVersion.Group,
 		Version:  cdicorev1alpha1.SchemeGroupVersion.Version,
 		Resource: "datavolumes",
 	}
-	if ar.Request.Resource != resource {
-		klog.Errorf("resource is %s but request is: %s", resource, ar.Request.Resource)
+	if ar.Resource != resource {
+		klog.Errorf("resource is %s but request is: %s", resource, ar.Resource)
 		err := fmt.Errorf("expect resource to be '%s'", resource.Resource)
 		return toAdmissionResponseError(err)
 	}
 
-	raw := ar.Request.Object.Raw
+	raw := ar.Object.Raw
 	dv := cdicorev1alpha1.DataVolume{}
 
 	err := json.Unmarshal(raw, &dv)
@@ -274,42 +708,50 @@ func admitDVs(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
 					Message: fmt.Sprintf("Destination PVC already exists"),
 					Field:   k8sfield.NewPath("DataVolume").Child("Name").String(),
 				})
+				recordRejectionEvent(&dv, causes)
 				return toRejectedAdmissionResponse(causes)
 			}
 		}
 	}
 
-	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), &dv.Spec)
+	// Authorization must run before validateDataVolumeSpec touches any cross-namespace source:
+	// validateDataVolumeSpec's existence/compatibility checks read and describe the source before
+	// any caller is confirmed authorized to read it, which would leak its existence and metadata
+	// to an unauthorized caller via the rejection cause.
+	causes := validateSourcePVCAuthorization(client, ar, &dv)
+	if len(causes) > 0 {
+		klog.Infof("rejected DataVolume admission, user not authorized to read source PVC")
+		recordRejectionEvent(&dv, causes)
+		return toRejectedAdmissionResponse(causes)
+	}
+
+	causes = validateSourceSnapshotAuthorization(client, ar, &dv)
+	if len(causes) > 0 {
+		klog.Infof("rejected DataVolume admission, user not authorized to read source snapshot")
+		recordRejectionEvent(&dv, causes)
+		return toRejectedAdmissionResponse(causes)
+	}
+
+	causes = validateDataVolumeSpec(k8sfield.NewPath("spec"), dv.GetNamespace(), dv.GetAnnotations(), &dv.Spec)
 	if len(causes) > 0 {
 		klog.Infof("rejected DataVolume admission")
+		recordRejectionEvent(&dv, causes)
 		return toRejectedAdmissionResponse(causes)
 	}
 
-	reviewResponse := v1beta1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-	return &reviewResponse
+	return &admissionResponse{Allowed: true}
 }
 
 func serve(resp http.ResponseWriter, req *http.Request, admit admitFunc) {
-
-	response := v1beta1.AdmissionReview{}
-	review, err := toAdmissionReview(req)
-
+	review, apiVersion, err := toAdmissionRequest(req)
 	if err != nil {
 		resp.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	reviewResponse := admit(review)
-	if reviewResponse != nil {
-		response.Response = reviewResponse
-		response.Response.UID = review.Request.UID
-	}
-	// reset the Object and OldObject, they are not needed in a response.
-	review.Request.Object = runtime.RawExtension{}
-	review.Request.OldObject = runtime.RawExtension{}
 
-	responseBytes, err := json.Marshal(response)
+	responseBytes, err := encodeAdmissionResponse(apiVersion, review.UID, reviewResponse)
 	if err != nil {
 		klog.Errorf("failed json encode webhook response: %s", err)
 		resp.WriteHeader(http.StatusBadRequest)
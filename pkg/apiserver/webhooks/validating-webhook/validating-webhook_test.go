@@ -0,0 +1,511 @@
+package validatingwebhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfield "k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	snapshotv1beta1 "github.com/kubernetes-csi/external-snapshotter/pkg/apis/volumesnapshot/v1beta1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/pkg/client/clientset/versioned/fake"
+
+	cdicorev1alpha1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func newAdmissionReviewForUser(username string, groups []string) *admissionRequest {
+	return &admissionRequest{
+		UserInfo: authenticationv1.UserInfo{
+			Username: username,
+			Groups:   groups,
+		},
+	}
+}
+
+func newDVWithPVCSource(dvNamespace, sourceNamespace, sourceName string) *cdicorev1alpha1.DataVolume {
+	return &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{Namespace: dvNamespace, Name: "target-dv"},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			Source: cdicorev1alpha1.DataVolumeSource{
+				PVC: &cdicorev1alpha1.DataVolumeSourcePVC{
+					Namespace: sourceNamespace,
+					Name:      sourceName,
+				},
+			},
+		},
+	}
+}
+
+func fakeClientAllowingSAR(allowed bool) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "localsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.LocalSubjectAccessReview)
+		sar.Status.Allowed = allowed
+		return true, sar, nil
+	})
+	return client
+}
+
+func TestValidateSourcePVCAuthorizationSameNamespace(t *testing.T) {
+	dv := newDVWithPVCSource("ns1", "ns1", "source-pvc")
+	ar := newAdmissionReviewForUser("alice", nil)
+	causes := validateSourcePVCAuthorization(fakeClientAllowingSAR(false), ar, dv)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes for a same-namespace clone, got %v", causes)
+	}
+}
+
+func TestValidateSourcePVCAuthorizationAllowed(t *testing.T) {
+	dv := newDVWithPVCSource("ns1", "ns2", "source-pvc")
+	ar := newAdmissionReviewForUser("alice", []string{"system:authenticated"})
+	causes := validateSourcePVCAuthorization(fakeClientAllowingSAR(true), ar, dv)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes when the SubjectAccessReview allows the user, got %v", causes)
+	}
+}
+
+func TestValidateSourcePVCAuthorizationForbidden(t *testing.T) {
+	dv := newDVWithPVCSource("ns1", "ns2", "source-pvc")
+	ar := newAdmissionReviewForUser("mallory", nil)
+	causes := validateSourcePVCAuthorization(fakeClientAllowingSAR(false), ar, dv)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause when the SubjectAccessReview denies the user, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueForbidden {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueForbidden, causes[0].Type)
+	}
+}
+
+func newDVWithSnapshotSource(dvNamespace, sourceNamespace, sourceName string) *cdicorev1alpha1.DataVolume {
+	return &cdicorev1alpha1.DataVolume{
+		ObjectMeta: metav1.ObjectMeta{Namespace: dvNamespace, Name: "target-dv"},
+		Spec: cdicorev1alpha1.DataVolumeSpec{
+			Source: cdicorev1alpha1.DataVolumeSource{
+				Snapshot: &cdicorev1alpha1.DataVolumeSourceSnapshot{
+					Namespace: sourceNamespace,
+					Name:      sourceName,
+				},
+			},
+		},
+	}
+}
+
+func TestValidateSourceSnapshotAuthorizationSameNamespace(t *testing.T) {
+	dv := newDVWithSnapshotSource("ns1", "ns1", "source-snapshot")
+	ar := newAdmissionReviewForUser("alice", nil)
+	causes := validateSourceSnapshotAuthorization(fakeClientAllowingSAR(false), ar, dv)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes for a same-namespace clone, got %v", causes)
+	}
+}
+
+func TestValidateSourceSnapshotAuthorizationAllowed(t *testing.T) {
+	dv := newDVWithSnapshotSource("ns1", "ns2", "source-snapshot")
+	ar := newAdmissionReviewForUser("alice", []string{"system:authenticated"})
+	causes := validateSourceSnapshotAuthorization(fakeClientAllowingSAR(true), ar, dv)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes when the SubjectAccessReview allows the user, got %v", causes)
+	}
+}
+
+func TestValidateSourceSnapshotAuthorizationForbidden(t *testing.T) {
+	dv := newDVWithSnapshotSource("ns1", "ns2", "source-snapshot")
+	ar := newAdmissionReviewForUser("mallory", nil)
+	causes := validateSourceSnapshotAuthorization(fakeClientAllowingSAR(false), ar, dv)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause when the SubjectAccessReview denies the user, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueForbidden {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueForbidden, causes[0].Type)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotAndPVCMutuallyExclusive(t *testing.T) {
+	spec := &cdicorev1alpha1.DataVolumeSpec{
+		Source: cdicorev1alpha1.DataVolumeSource{
+			PVC: &cdicorev1alpha1.DataVolumeSourcePVC{Namespace: "ns1", Name: "source-pvc"},
+			Snapshot: &cdicorev1alpha1.DataVolumeSourceSnapshot{
+				Namespace: "ns1",
+				Name:      "source-snapshot",
+			},
+		},
+	}
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for mutually exclusive sources, got %v", causes)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotMissingNamespaceOrName(t *testing.T) {
+	spec := &cdicorev1alpha1.DataVolumeSpec{
+		Source: cdicorev1alpha1.DataVolumeSource{
+			Snapshot: &cdicorev1alpha1.DataVolumeSourceSnapshot{Name: "source-snapshot"},
+		},
+		PVC: &v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{"storage": resource.MustParse("1Gi")},
+			},
+		},
+	}
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for a source snapshot missing its namespace, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueInvalid {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueInvalid, causes[0].Type)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotSourceWithoutPVCDoesNotPanic(t *testing.T) {
+	spec := &cdicorev1alpha1.DataVolumeSpec{
+		Source: cdicorev1alpha1.DataVolumeSource{
+			Snapshot: &cdicorev1alpha1.DataVolumeSourceSnapshot{Namespace: "ns1", Name: "source-snapshot"},
+		},
+	}
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for a missing PVC, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueInvalid {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueInvalid, causes[0].Type)
+	}
+}
+
+func readyToUse(ready bool) *bool {
+	return &ready
+}
+
+func newSnapshotSourceSpec(snapshotNamespace, snapshotName, pvcSize string) *cdicorev1alpha1.DataVolumeSpec {
+	return &cdicorev1alpha1.DataVolumeSpec{
+		Source: cdicorev1alpha1.DataVolumeSource{
+			Snapshot: &cdicorev1alpha1.DataVolumeSourceSnapshot{Namespace: snapshotNamespace, Name: snapshotName},
+		},
+		PVC: &v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{"storage": resource.MustParse(pvcSize)},
+			},
+		},
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotSourceNotFound(t *testing.T) {
+	SetSnapshotClient(snapshotfake.NewSimpleClientset())
+	defer SetSnapshotClient(nil)
+
+	spec := newSnapshotSourceSpec("ns2", "missing-snapshot", "1Gi")
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for a missing source snapshot, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueNotFound {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueNotFound, causes[0].Type)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotSourceNotReady(t *testing.T) {
+	notReady := &snapshotv1beta1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "source-snapshot"},
+		Status:     &snapshotv1beta1.VolumeSnapshotStatus{ReadyToUse: readyToUse(false)},
+	}
+	SetSnapshotClient(snapshotfake.NewSimpleClientset(notReady))
+	defer SetSnapshotClient(nil)
+
+	spec := newSnapshotSourceSpec("ns2", "source-snapshot", "1Gi")
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for a not-ready source snapshot, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueInvalid {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueInvalid, causes[0].Type)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotSourceRestoreSizeTooSmall(t *testing.T) {
+	restoreSize := resource.MustParse("5Gi")
+	ready := &snapshotv1beta1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "source-snapshot"},
+		Status: &snapshotv1beta1.VolumeSnapshotStatus{
+			ReadyToUse:  readyToUse(true),
+			RestoreSize: &restoreSize,
+		},
+	}
+	SetSnapshotClient(snapshotfake.NewSimpleClientset(ready))
+	defer SetSnapshotClient(nil)
+
+	spec := newSnapshotSourceSpec("ns2", "source-snapshot", "1Gi")
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 1 {
+		t.Fatalf("expected a single cause for a target PVC smaller than the restore size, got %v", causes)
+	}
+	if causes[0].Type != metav1.CauseTypeFieldValueInvalid {
+		t.Errorf("expected cause type %s, got %s", metav1.CauseTypeFieldValueInvalid, causes[0].Type)
+	}
+}
+
+func TestValidateDataVolumeSpecSnapshotSourceSuccess(t *testing.T) {
+	restoreSize := resource.MustParse("1Gi")
+	ready := &snapshotv1beta1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "source-snapshot"},
+		Status: &snapshotv1beta1.VolumeSnapshotStatus{
+			ReadyToUse:  readyToUse(true),
+			RestoreSize: &restoreSize,
+		},
+	}
+	SetSnapshotClient(snapshotfake.NewSimpleClientset(ready))
+	defer SetSnapshotClient(nil)
+
+	spec := newSnapshotSourceSpec("ns2", "source-snapshot", "5Gi")
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", nil, spec)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes for a ready snapshot and large enough PVC, got %v", causes)
+	}
+}
+
+func TestValidateDataVolumeSpecHTTPSourceSkipsProbeWithoutClient(t *testing.T) {
+	spec := &cdicorev1alpha1.DataVolumeSpec{
+		Source: cdicorev1alpha1.DataVolumeSource{
+			HTTP: &cdicorev1alpha1.DataVolumeSourceHTTP{URL: "http://example.com/disk.img"},
+		},
+		PVC: &v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{"storage": resource.MustParse("1Gi")},
+			},
+		},
+	}
+	// GetClient() is unconfigured in this test binary, so probeSourceURL is never reached and
+	// the annotation has nothing to do; this just documents that admission doesn't panic or
+	// block when there is no client to probe with.
+	annotations := map[string]string{AnnSourceProbeSkip: "true"}
+	causes := validateDataVolumeSpec(k8sfield.NewPath("spec"), "ns1", annotations, spec)
+	if len(causes) != 0 {
+		t.Errorf("expected no causes, got %v", causes)
+	}
+}
+
+func TestRecordRejectionEventIsNoOpWithoutRecorder(t *testing.T) {
+	SetRecorder(nil)
+	dv := newDVWithPVCSource("ns1", "ns2", "source-pvc")
+	recordRejectionEvent(dv, []metav1.StatusCause{{Message: "boom"}})
+}
+
+func TestRecordRejectionEventEmitsWarning(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(1)
+	SetRecorder(fakeRecorder)
+	defer SetRecorder(nil)
+
+	dv := newDVWithPVCSource("ns1", "ns2", "source-pvc")
+	recordRejectionEvent(dv, []metav1.StatusCause{{Message: "source PVC is not valid"}})
+
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, "Warning") || !strings.Contains(event, "DataVolumeRejected") {
+			t.Errorf("expected a Warning DataVolumeRejected event, got %q", event)
+		}
+	default:
+		t.Fatal("expected a rejection event to be recorded")
+	}
+}
+
+func TestAdmitDVsRejectsUnauthorizedSourcePVCBeforeValidatingSpec(t *testing.T) {
+	client := fakeClientAllowingSAR(false)
+	SetClient(client)
+	defer SetClient(nil)
+
+	dv := newDVWithPVCSource("ns1", "ns2", "missing-source-pvc")
+	raw, err := json.Marshal(dv)
+	if err != nil {
+		t.Fatalf("unable to marshal DataVolume: %v", err)
+	}
+
+	ar := &admissionRequest{
+		Resource: metav1.GroupVersionResource{
+			Group:    cdicorev1alpha1.SchemeGroupVersion.Group,
+			Version:  cdicorev1alpha1.SchemeGroupVersion.Version,
+			Resource: "datavolumes",
+		},
+		Object:   runtime.RawExtension{Raw: raw},
+		UserInfo: authenticationv1.UserInfo{Username: "mallory"},
+	}
+
+	resp := admitDVs(ar)
+	if resp.Allowed {
+		t.Fatal("expected admission to be rejected")
+	}
+	// If validateDataVolumeSpec's PVC lookup ran first, the source PVC (which doesn't exist)
+	// would produce a NotFound cause instead, leaking that the PVC is absent to a caller who was
+	// never authorized to know that.
+	causes := resp.Result.Details.Causes
+	if len(causes) != 1 || causes[0].Type != metav1.CauseTypeFieldValueForbidden {
+		t.Fatalf("expected a single Forbidden cause from the authorization check, got %v", causes)
+	}
+}
+
+func postAdmissionReview(t *testing.T, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	serve(resp, req, func(ar *admissionRequest) *admissionResponse {
+		return &admissionResponse{Allowed: true}
+	})
+	return resp
+}
+
+func TestServeRoundTripsAdmissionV1beta1(t *testing.T) {
+	uid := types.UID("abc-123")
+	review := v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionV1beta1, Kind: "AdmissionReview"},
+		Request:  &v1beta1.AdmissionRequest{UID: uid},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("unable to marshal request: %v", err)
+	}
+
+	resp := postAdmissionReview(t, body)
+
+	var result v1beta1.AdmissionReview
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unable to unmarshal response: %v", err)
+	}
+	if result.Response == nil || !result.Response.Allowed || result.Response.UID != uid {
+		t.Errorf("expected an allowed v1beta1 response echoing UID %s, got %+v", uid, result.Response)
+	}
+}
+
+func TestServeRoundTripsAdmissionV1(t *testing.T) {
+	uid := types.UID("def-456")
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionV1, Kind: "AdmissionReview"},
+		Request:  &admissionv1.AdmissionRequest{UID: uid},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("unable to marshal request: %v", err)
+	}
+
+	resp := postAdmissionReview(t, body)
+
+	var result admissionv1.AdmissionReview
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unable to unmarshal response: %v", err)
+	}
+	if result.Response == nil || !result.Response.Allowed || result.Response.UID != uid {
+		t.Errorf("expected an allowed v1 response echoing UID %s, got %+v", uid, result.Response)
+	}
+}
+
+func TestProbeSourceURLSuccessReturnsContentLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected a HEAD request, got %s", r.Method)
+		}
+		w.Header().Set("Content-Length", "1234")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	contentLength, probeErr := probeSourceURL(fake.NewSimpleClientset(), "ns1", server.URL, "", "", false)
+	if probeErr != "" {
+		t.Fatalf("expected no probe error, got %q", probeErr)
+	}
+	if contentLength != 1234 {
+		t.Errorf("expected content length 1234, got %d", contentLength)
+	}
+}
+
+func TestProbeSourceURLUsesRangedGetAndParsesContentRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected a GET request, got %s", r.Method)
+		}
+		if r.Header.Get("Range") != "bytes=0-0" {
+			t.Errorf("expected a Range: bytes=0-0 header, got %q", r.Header.Get("Range"))
+		}
+		w.Header().Set("Content-Range", "bytes 0-0/1234")
+		w.Header().Set("Content-Length", "1")
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer server.Close()
+
+	contentLength, probeErr := probeSourceURL(fake.NewSimpleClientset(), "ns1", server.URL, "", "", true)
+	if probeErr != "" {
+		t.Fatalf("expected no probe error, got %q", probeErr)
+	}
+	if contentLength != 1234 {
+		t.Errorf("expected content length 1234 recovered from Content-Range, got %d", contentLength)
+	}
+}
+
+func TestProbeSourceURLRejectsHTTPErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, probeErr := probeSourceURL(fake.NewSimpleClientset(), "ns1", server.URL, "", "", false)
+	if probeErr == "" {
+		t.Fatal("expected a probe error for a 404 response")
+	}
+	if !strings.Contains(probeErr, "404") {
+		t.Errorf("expected the probe error to mention the status code, got %q", probeErr)
+	}
+}
+
+func TestProbeSourceURLUsesSecretRefForBasicAuth(t *testing.T) {
+	const accessKeyID = "theaccesskey"
+	const secretKey = "thesecretkey"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != accessKeyID || pass != secretKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "source-creds"},
+		Data: map[string][]byte{
+			"accessKeyId": []byte(accessKeyID),
+			"secretKey":   []byte(secretKey),
+		},
+	})
+
+	_, probeErr := probeSourceURL(client, "ns1", server.URL, "source-creds", "", false)
+	if probeErr != "" {
+		t.Fatalf("expected no probe error with valid SecretRef credentials, got %q", probeErr)
+	}
+}
+
+func TestProbeSourceURLRejectsMissingSecretRef(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, probeErr := probeSourceURL(fake.NewSimpleClientset(), "ns1", server.URL, "missing-secret", "", false)
+	if probeErr == "" {
+		t.Fatal("expected a probe error when SecretRef does not exist")
+	}
+}
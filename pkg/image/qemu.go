@@ -0,0 +1,108 @@
+// Package image wraps the qemu-img binary used by the importer to convert, resize, and inspect
+// disk images.
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+)
+
+// ImgInfo is the subset of `qemu-img info --output=json` the importer cares about.
+type ImgInfo struct {
+	// Format is the on-disk format of the image (qcow2, raw, ...).
+	Format string `json:"format"`
+	// BackingFile is the backing file of the image, if any.
+	BackingFile string `json:"backing-filename"`
+	// VirtualSize is the virtual (guest-visible) size of the image, in bytes.
+	VirtualSize int64 `json:"virtual-size"`
+	// ActualSize is the size the image occupies on disk, in bytes.
+	ActualSize int64 `json:"actual-size"`
+}
+
+// QEMUOperations is the set of qemu-img operations the importer needs to bring a source image
+// into the target PVC. Every method takes a context so a canceled import (pod SIGTERM, DataVolume
+// deletion) can abort an in-flight qemu-img invocation instead of leaving a zombie process holding
+// the scratch PVC.
+type QEMUOperations interface {
+	ConvertToRawStream(ctx context.Context, url *url.URL, dest string) error
+	Resize(ctx context.Context, dest string, size resource.Quantity) error
+	Info(ctx context.Context, url *url.URL) (*ImgInfo, error)
+	Validate(ctx context.Context, url *url.URL, size int64) error
+	CreateBlankImage(ctx context.Context, dest string, size resource.Quantity) error
+}
+
+type qemuOperations struct{}
+
+// NewQEMUOperations returns a QEMUOperations backed by the qemu-img binary on PATH.
+func NewQEMUOperations() QEMUOperations {
+	return &qemuOperations{}
+}
+
+func execQemuImg(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "qemu-img", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "qemu-img %v failed: %s", args, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func (o *qemuOperations) ConvertToRawStream(ctx context.Context, url *url.URL, dest string) error {
+	klog.V(1).Infof("converting %s to %s", url, dest)
+	_, err := execQemuImg(ctx, "convert", "-p", "-O", "raw", url.String(), dest)
+	return err
+}
+
+func (o *qemuOperations) Resize(ctx context.Context, dest string, size resource.Quantity) error {
+	klog.V(1).Infof("resizing %s to %s", dest, size.String())
+	_, err := execQemuImg(ctx, "resize", dest, fmt.Sprintf("%d", size.Value()))
+	return err
+}
+
+func (o *qemuOperations) Info(ctx context.Context, imgURL *url.URL) (*ImgInfo, error) {
+	out, err := execQemuImg(ctx, "info", "--output=json", imgURL.String())
+	if err != nil {
+		return nil, err
+	}
+	info := &ImgInfo{}
+	if err := json.Unmarshal(out, info); err != nil {
+		return nil, errors.Wrap(err, "could not parse qemu-img info output")
+	}
+	return info, nil
+}
+
+// Validate rejects images with a backing file (disallowed, since a backing file outside the
+// scratch/data directory would leak host paths into the guest) or whose virtual size does not fit
+// within size.
+func (o *qemuOperations) Validate(ctx context.Context, imgURL *url.URL, size int64) error {
+	info, err := o.Info(ctx, imgURL)
+	if err != nil {
+		return err
+	}
+	if info.BackingFile != "" {
+		return errors.Errorf("image %s has an invalid backing file %s", imgURL, info.BackingFile)
+	}
+	if size > 0 && info.VirtualSize > size {
+		return errors.Errorf("image %s virtual size %d is larger than available size %d", imgURL, info.VirtualSize, size)
+	}
+	return nil
+}
+
+func (o *qemuOperations) CreateBlankImage(ctx context.Context, dest string, size resource.Quantity) error {
+	klog.V(1).Infof("creating raw image %s of size %s", dest, size.String())
+	_, err := execQemuImg(ctx, "create", "-f", "raw", dest, fmt.Sprintf("%d", size.Value()))
+	return err
+}
@@ -0,0 +1,49 @@
+package importer
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// BatchEntry describes a single disk within a multi-disk import manifest, e.g. one of several
+// disks attached to the same VM.
+type BatchEntry struct {
+	Provider       DataProvider
+	Dest           string
+	DataDir        string
+	ScratchDataDir string
+	ImageSize      string
+}
+
+// BatchDataProvider drives the DataProcessor phase machine once per entry in a manifest, e.g. once
+// per disk attached to the same VM. Each entry keeps its own scratch directory, since scratch space
+// is sized and provisioned per disk; what's shared across entries is the timeouts and observer, so
+// a single PhaseObserver (e.g. a PrometheusPhaseObserver) sees aggregate progress for the batch
+// instead of only the last entry processed.
+type BatchDataProvider struct {
+	entries  []BatchEntry
+	timeouts PhaseTimeouts
+	observer PhaseObserver
+}
+
+// NewBatchDataProvider creates a BatchDataProvider over entries, applying timeouts and observer to
+// every entry's DataProcessor.
+func NewBatchDataProvider(entries []BatchEntry, timeouts PhaseTimeouts, observer PhaseObserver) *BatchDataProvider {
+	if observer == nil {
+		observer = noopObserver{}
+	}
+	return &BatchDataProvider{entries: entries, timeouts: timeouts, observer: observer}
+}
+
+// ProcessAll runs every entry's DataProcessor to completion in manifest order, stopping at the
+// first error so a failed disk doesn't leave later disks partially imported.
+func (b *BatchDataProvider) ProcessAll(ctx context.Context) error {
+	for i, entry := range b.entries {
+		dp := NewDataProcessorWithObserver(entry.Provider, entry.Dest, entry.DataDir, entry.ScratchDataDir, entry.ImageSize, b.timeouts, b.observer)
+		if err := dp.ProcessDataWithContext(ctx); err != nil {
+			return errors.Wrapf(err, "failed importing manifest entry %d (%s)", i, entry.Dest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package importer
+
+import (
+	"context"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch data provider", func() {
+	It("processes every entry in order and reports each phase to the shared observer", func() {
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		first := &MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseComplete}
+		second := &MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseComplete}
+		observer := &recordingObserver{}
+
+		b := NewBatchDataProvider([]BatchEntry{
+			{Provider: first, Dest: "dest1", DataDir: "dataDir1", ScratchDataDir: "scratch1"},
+			{Provider: second, Dest: "dest2", DataDir: "dataDir2", ScratchDataDir: "scratch2"},
+		}, PhaseTimeouts{}, observer)
+
+		Expect(b.ProcessAll(context.Background())).To(Succeed())
+		Expect(first.calledPhases).To(Equal([]ProcessingPhase{ProcessingPhaseInfo}))
+		Expect(second.calledPhases).To(Equal([]ProcessingPhase{ProcessingPhaseInfo}))
+		Expect(len(observer.ends)).To(Equal(2))
+	})
+
+	It("stops at the first failing entry and does not process the rest", func() {
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		failing := &MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseError}
+		second := &MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseComplete}
+
+		b := NewBatchDataProvider([]BatchEntry{
+			{Provider: failing, Dest: "dest1", DataDir: "dataDir1", ScratchDataDir: "scratch1"},
+			{Provider: second, Dest: "dest2", DataDir: "dataDir2", ScratchDataDir: "scratch2"},
+		}, PhaseTimeouts{}, nil)
+
+		err = b.ProcessAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(second.calledPhases).To(BeEmpty())
+	})
+})
@@ -0,0 +1,323 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+
+	"kubevirt.io/containerized-data-importer/pkg/image"
+)
+
+// ProcessingPhase is a step in the state machine DataProcessor drives a DataProvider through.
+type ProcessingPhase string
+
+const (
+	// ProcessingPhaseInfo is the first phase: ask the provider what it has to offer.
+	ProcessingPhaseInfo ProcessingPhase = "Info"
+	// ProcessingPhaseTransferScratch transfers the source data into the scratch space.
+	ProcessingPhaseTransferScratch ProcessingPhase = "TransferScratch"
+	// ProcessingPhaseTransferDataDir transfers the source data directly into the data directory.
+	ProcessingPhaseTransferDataDir ProcessingPhase = "TransferDataDir"
+	// ProcessingPhaseTransferDataFile transfers the source data into a single destination file.
+	ProcessingPhaseTransferDataFile ProcessingPhase = "TransferDataFile"
+	// ProcessingPhaseTransferChunked transfers the source data in resumable, checkpointed chunks
+	// via a ResumableDataProvider.
+	ProcessingPhaseTransferChunked ProcessingPhase = "TransferChunked"
+	// ProcessingPhaseProcess lets the provider do any source-specific processing before convert.
+	ProcessingPhaseProcess ProcessingPhase = "Process"
+	// ProcessingPhaseConvert converts the transferred data to a raw image at dest.
+	ProcessingPhaseConvert ProcessingPhase = "Convert"
+	// ProcessingPhaseResize grows the converted image to match the requested PVC size.
+	ProcessingPhaseResize ProcessingPhase = "Resize"
+	// ProcessingPhaseComplete indicates processing finished successfully.
+	ProcessingPhaseComplete ProcessingPhase = "Complete"
+	// ProcessingPhaseError indicates the provider or DataProcessor hit an unrecoverable error.
+	ProcessingPhaseError ProcessingPhase = "Error"
+)
+
+var (
+	// ErrRequiresScratchSpace is returned when a transfer needs scratch space but none was usable.
+	ErrRequiresScratchSpace = errors.New("scratch space required and none found")
+	// ErrInvalidPath is returned by a DataProvider when it was handed a path it cannot write to.
+	ErrInvalidPath = errors.New("invalid transfer path")
+	// ErrPhaseTimeout is returned when a phase does not complete within its configured deadline.
+	ErrPhaseTimeout = errors.New("processing phase timed out")
+)
+
+// DataProvider drives a single source (HTTP, S3, registry, PVC clone, ...) through the phases of
+// DataProcessor's state machine. Every method takes a context so a canceled import (pod SIGTERM,
+// DataVolume deletion) aborts an in-flight transfer instead of running to completion regardless.
+type DataProvider interface {
+	// Info is called first to learn how the data should be transferred.
+	Info(ctx context.Context) (ProcessingPhase, error)
+	// Transfer moves the data into path, either the scratch or the target data directory.
+	Transfer(ctx context.Context, path string) (ProcessingPhase, error)
+	// TransferFile moves the data into the single file named fileName.
+	TransferFile(ctx context.Context, fileName string) (ProcessingPhase, error)
+	// Process does any source-specific processing needed before conversion.
+	Process(ctx context.Context) (ProcessingPhase, error)
+	// GetURL returns the URL the processor should hand to qemu-img.
+	GetURL() *url.URL
+	// Close releases any readers or other resources the provider opened.
+	Close() error
+}
+
+// qemuOperations is replaced wholesale in tests via replaceQEMUOperations.
+var qemuOperations = image.NewQEMUOperations()
+
+// getAvailableSpaceBlockFunc is replaced in tests via replaceAvailableSpaceBlockFunc.
+var getAvailableSpaceBlockFunc = GetAvailableSpaceBlock
+
+// defaultChunkSize is the number of bytes DataProcessor asks a ResumableDataProvider to transfer
+// per call to TransferRange.
+const defaultChunkSize = 64 * 1024 * 1024
+
+// PhaseTimeouts configures a per-phase deadline; a zero Duration means no deadline. Transfer
+// bounds the transfer phases and the Process phase. No command-line flags set these values; they
+// are only set by callers of NewDataProcessorWithTimeouts, NewDataProcessorWithObserver and
+// NewBatchDataProvider.
+type PhaseTimeouts struct {
+	Info     time.Duration
+	Transfer time.Duration
+	Convert  time.Duration
+}
+
+// DataProcessor drives a DataProvider through Info/Transfer/Process/Convert/Resize until the data
+// lands, converted and resized, at dest.
+type DataProcessor struct {
+	dataProvider   DataProvider
+	dest           string
+	dataDir        string
+	scratchDataDir string
+	imageSize      string
+	availableSpace int64
+	timeouts       PhaseTimeouts
+	chunkSize      int64
+	observer       PhaseObserver
+}
+
+// NewDataProcessor creates a DataProcessor with no per-phase timeouts configured.
+func NewDataProcessor(dataProvider DataProvider, dest, dataDir, scratchDataDir, imageSize string) *DataProcessor {
+	return NewDataProcessorWithTimeouts(dataProvider, dest, dataDir, scratchDataDir, imageSize, PhaseTimeouts{})
+}
+
+// NewDataProcessorWithTimeouts creates a DataProcessor that aborts any phase exceeding its
+// configured deadline in timeouts.
+func NewDataProcessorWithTimeouts(dataProvider DataProvider, dest, dataDir, scratchDataDir, imageSize string, timeouts PhaseTimeouts) *DataProcessor {
+	return NewDataProcessorWithObserver(dataProvider, dest, dataDir, scratchDataDir, imageSize, timeouts, noopObserver{})
+}
+
+// NewDataProcessorWithObserver creates a DataProcessor that reports every phase transition and
+// transfer progress update to observer.
+func NewDataProcessorWithObserver(dataProvider DataProvider, dest, dataDir, scratchDataDir, imageSize string, timeouts PhaseTimeouts, observer PhaseObserver) *DataProcessor {
+	dp := &DataProcessor{
+		dataProvider:   dataProvider,
+		dest:           dest,
+		dataDir:        dataDir,
+		scratchDataDir: scratchDataDir,
+		imageSize:      imageSize,
+		timeouts:       timeouts,
+		chunkSize:      defaultChunkSize,
+		observer:       observer,
+	}
+	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
+		// dest is a raw block device; there is no data directory to stat for free space.
+		dp.availableSpace = getAvailableSpaceBlockFunc(dest)
+	} else {
+		dp.availableSpace = GetAvailableSpace(dataDir)
+	}
+	return dp
+}
+
+// ProcessData runs the phase state machine to completion with no cancellation, for callers that
+// predate context support.
+func (dp *DataProcessor) ProcessData() error {
+	return dp.ProcessDataWithContext(context.Background())
+}
+
+// ProcessDataWithContext runs the phase state machine to completion or until ctx is canceled or a
+// configured phase deadline elapses, in which case it returns ErrPhaseTimeout.
+func (dp *DataProcessor) ProcessDataWithContext(ctx context.Context) error {
+	defer dp.dataProvider.Close()
+
+	currentPhase, err := dp.observe(ProcessingPhaseInfo, func() (ProcessingPhase, error) {
+		return dp.withTimeout(ctx, dp.timeouts.Info, dp.dataProvider.Info)
+	})
+	if err != nil {
+		return err
+	}
+
+	for currentPhase != ProcessingPhaseComplete {
+		phase := currentPhase
+		switch phase {
+		case ProcessingPhaseTransferScratch:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.transfer(ctx, dp.scratchDataDir, true) })
+		case ProcessingPhaseTransferDataDir:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.transfer(ctx, dp.dataDir, false) })
+		case ProcessingPhaseTransferDataFile:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.transferFile(ctx) })
+		case ProcessingPhaseTransferChunked:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.transferChunked(ctx) })
+		case ProcessingPhaseProcess:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) {
+				return dp.withTimeout(ctx, dp.timeouts.Transfer, dp.dataProvider.Process)
+			})
+		case ProcessingPhaseConvert:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.convert(ctx, dp.dataProvider.GetURL()) })
+		case ProcessingPhaseResize:
+			currentPhase, err = dp.observe(phase, func() (ProcessingPhase, error) { return dp.resize(ctx) })
+		default:
+			return fmt.Errorf("unknown processing phase %s", currentPhase)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTimeout runs phaseFunc, optionally bounded by timeout, translating a context deadline into
+// ErrPhaseTimeout so callers can tell a timeout apart from a provider-reported error.
+func (dp *DataProcessor) withTimeout(ctx context.Context, timeout time.Duration, phaseFunc func(context.Context) (ProcessingPhase, error)) (ProcessingPhase, error) {
+	if timeout <= 0 {
+		return phaseFunc(ctx)
+	}
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	phase, err := phaseFunc(phaseCtx)
+	if err != nil && phaseCtx.Err() == context.DeadlineExceeded {
+		return ProcessingPhaseError, ErrPhaseTimeout
+	}
+	return phase, err
+}
+
+func (dp *DataProcessor) transfer(ctx context.Context, path string, scratch bool) (ProcessingPhase, error) {
+	next, err := dp.withTimeout(ctx, dp.timeouts.Transfer, func(ctx context.Context) (ProcessingPhase, error) {
+		return dp.dataProvider.Transfer(ctx, path)
+	})
+	if err != nil {
+		if scratch && err == ErrInvalidPath {
+			return ProcessingPhaseError, ErrRequiresScratchSpace
+		}
+		return next, err
+	}
+	return next, nil
+}
+
+func (dp *DataProcessor) transferFile(ctx context.Context) (ProcessingPhase, error) {
+	next, err := dp.withTimeout(ctx, dp.timeouts.Transfer, func(ctx context.Context) (ProcessingPhase, error) {
+		return dp.dataProvider.TransferFile(ctx, dp.dest)
+	})
+	if err != nil {
+		return next, err
+	}
+	if next == ProcessingPhaseComplete {
+		if verr := qemuOperations.Validate(ctx, dp.dataProvider.GetURL(), dp.availableSpace); verr != nil {
+			return ProcessingPhaseError, errors.Wrap(verr, "Image validation failed")
+		}
+	}
+	return next, nil
+}
+
+// convert converts the transferred data at url to a raw image at dest, then validates the source
+// image at url (no backing file, virtual size within the available space). The converted image at
+// dest is not validated.
+func (dp *DataProcessor) convert(ctx context.Context, url *url.URL) (ProcessingPhase, error) {
+	convertCtx := ctx
+	if dp.timeouts.Convert > 0 {
+		var cancel context.CancelFunc
+		convertCtx, cancel = context.WithTimeout(ctx, dp.timeouts.Convert)
+		defer cancel()
+	}
+	if err := qemuOperations.ConvertToRawStream(convertCtx, url, dp.dest); err != nil {
+		if convertCtx.Err() == context.DeadlineExceeded {
+			return ProcessingPhaseError, ErrPhaseTimeout
+		}
+		return ProcessingPhaseError, errors.Wrap(err, "Conversion failed")
+	}
+	if err := qemuOperations.Validate(convertCtx, url, dp.availableSpace); err != nil {
+		return ProcessingPhaseError, errors.Wrap(err, "Image validation failed")
+	}
+	return ProcessingPhaseResize, nil
+}
+
+// resize grows dest to match dp.imageSize. A destination whose data directory does not exist is a
+// raw block device, already sized by the PVC, so there is nothing for qemu-img to resize.
+func (dp *DataProcessor) resize(ctx context.Context) (ProcessingPhase, error) {
+	if dp.imageSize == "" {
+		return ProcessingPhaseComplete, nil
+	}
+	if _, err := os.Stat(dp.dataDir); os.IsNotExist(err) {
+		klog.V(1).Infof("no data directory to resize, available block space is %d", getAvailableSpaceBlockFunc(dp.dest))
+		return ProcessingPhaseComplete, nil
+	}
+	if err := ResizeImage(ctx, dp.dest, dp.imageSize, dp.availableSpace); err != nil {
+		return ProcessingPhaseError, err
+	}
+	return ProcessingPhaseComplete, nil
+}
+
+// ResizeImage resizes the raw image at dest to imageSize, capped at totalSpace. It is a no-op if
+// the image is already at least as large as the requested size.
+func ResizeImage(ctx context.Context, dest, imageSize string, totalSpace int64) error {
+	if imageSize == "" {
+		return errors.New("no image size to resize to")
+	}
+	requestedSize, err := resource.ParseQuantity(imageSize)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse requested image size")
+	}
+	if totalSpace > 0 && requestedSize.Value() > totalSpace {
+		requestedSize = *resource.NewScaledQuantity(totalSpace, 0)
+	}
+
+	destURL, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	info, err := qemuOperations.Info(ctx, destURL)
+	if err != nil {
+		return err
+	}
+	currentSize := *resource.NewScaledQuantity(info.VirtualSize, 0)
+	if requestedSize.Cmp(currentSize) <= 0 {
+		klog.V(1).Infof("requested size %s is not bigger than the current size, not resizing", requestedSize.String())
+		return nil
+	}
+	return qemuOperations.Resize(ctx, dest, requestedSize)
+}
+
+// GetAvailableSpace returns the number of bytes free in the filesystem mounted at dataDir.
+func GetAvailableSpace(dataDir string) int64 {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dataDir, &stat); err != nil {
+		klog.Errorf("unable to stat %s: %v", dataDir, err)
+		return -1
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize)
+}
+
+// GetAvailableSpaceBlock returns the size, in bytes, of the block device at devicePath.
+func GetAvailableSpaceBlock(devicePath string) int64 {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		klog.Errorf("unable to open %s: %v", devicePath, err)
+		return -1
+	}
+	defer f.Close()
+
+	size, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKGETSIZE64)
+	if err != nil {
+		klog.Errorf("unable to get size of block device %s: %v", devicePath, err)
+		return -1
+	}
+	return int64(size)
+}
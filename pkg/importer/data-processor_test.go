@@ -1,10 +1,12 @@
 package importer
 
 import (
+	"context"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/extensions/table"
@@ -17,6 +19,27 @@ import (
 	"kubevirt.io/containerized-data-importer/pkg/image"
 )
 
+// recordedPhaseEnd is one call recorded by a recordingObserver.
+type recordedPhaseEnd struct {
+	phase    ProcessingPhase
+	err      error
+	duration time.Duration
+}
+
+// recordingObserver is a PhaseObserver that records every OnPhaseEnd call, for tests that need to
+// assert on phase duration or the error an observer actually receives.
+type recordingObserver struct {
+	ends []recordedPhaseEnd
+}
+
+func (o *recordingObserver) OnPhaseStart(phase ProcessingPhase) {}
+
+func (o *recordingObserver) OnPhaseEnd(phase ProcessingPhase, err error, duration time.Duration) {
+	o.ends = append(o.ends, recordedPhaseEnd{phase: phase, err: err, duration: duration})
+}
+
+func (o *recordingObserver) OnBytes(transferred, total int64) {}
+
 type fakeInfoOpRetVal struct {
 	imgInfo *image.ImgInfo
 	e       error
@@ -53,10 +76,13 @@ type MockDataProvider struct {
 	transferFile     string
 	calledPhases     []ProcessingPhase
 	needsScratch     bool
+	// blockUntilCanceled, when set, makes Transfer block until ctx is canceled instead of
+	// returning transferResponse immediately.
+	blockUntilCanceled bool
 }
 
 // Info is called to get initial information about the data
-func (m *MockDataProvider) Info() (ProcessingPhase, error) {
+func (m *MockDataProvider) Info(ctx context.Context) (ProcessingPhase, error) {
 	m.calledPhases = append(m.calledPhases, ProcessingPhaseInfo)
 	if m.infoResponse == ProcessingPhaseError {
 		return ProcessingPhaseError, errors.New("Info errored")
@@ -65,9 +91,13 @@ func (m *MockDataProvider) Info() (ProcessingPhase, error) {
 }
 
 // Transfer is called to transfer the data from the source to the passed in path.
-func (m *MockDataProvider) Transfer(path string) (ProcessingPhase, error) {
+func (m *MockDataProvider) Transfer(ctx context.Context, path string) (ProcessingPhase, error) {
 	m.calledPhases = append(m.calledPhases, m.infoResponse)
 	m.transferPath = path
+	if m.blockUntilCanceled {
+		<-ctx.Done()
+		return ProcessingPhaseError, ctx.Err()
+	}
 	if m.transferResponse == ProcessingPhaseError {
 		if m.needsScratch {
 			return ProcessingPhaseError, ErrInvalidPath
@@ -78,7 +108,7 @@ func (m *MockDataProvider) Transfer(path string) (ProcessingPhase, error) {
 }
 
 // TransferFile is called to transfer the data from the source to the passed in file.
-func (m *MockDataProvider) TransferFile(fileName string) (ProcessingPhase, error) {
+func (m *MockDataProvider) TransferFile(ctx context.Context, fileName string) (ProcessingPhase, error) {
 	m.calledPhases = append(m.calledPhases, ProcessingPhaseTransferDataFile)
 	m.transferFile = fileName
 	if m.transferResponse == ProcessingPhaseError {
@@ -88,7 +118,7 @@ func (m *MockDataProvider) TransferFile(fileName string) (ProcessingPhase, error
 }
 
 // Process is called to do any special processing before giving the url to the data back to the processor
-func (m *MockDataProvider) Process() (ProcessingPhase, error) {
+func (m *MockDataProvider) Process(ctx context.Context) (ProcessingPhase, error) {
 	m.calledPhases = append(m.calledPhases, ProcessingPhaseProcess)
 	if m.processResponse == ProcessingPhaseError {
 		return ProcessingPhaseError, errors.New("Process errored")
@@ -253,6 +283,27 @@ var _ = Describe("Data Processor", func() {
 			Expect(tmpDir).To(Equal(mdp.transferPath))
 		})
 	})
+
+	It("should cancel an in-flight Transfer phase and return once its deadline elapses", func() {
+		mdp := &MockDataProvider{
+			infoResponse:       ProcessingPhaseTransferScratch,
+			blockUntilCanceled: true,
+		}
+		dp := NewDataProcessorWithTimeouts(mdp, "dest", "dataDir", "scratchDataDir", "1G", PhaseTimeouts{Transfer: 50 * time.Millisecond})
+
+		done := make(chan error, 1)
+		go func() { done <- dp.ProcessDataWithContext(context.Background()) }()
+
+		select {
+		case err := <-done:
+			Expect(err).To(Equal(ErrPhaseTimeout))
+		case <-time.After(5 * time.Second):
+			Fail("ProcessData did not return within the phase deadline")
+		}
+		Expect(2).To(Equal(len(mdp.calledPhases)))
+		Expect(ProcessingPhaseInfo).To(Equal(mdp.calledPhases[0]))
+		Expect(ProcessingPhaseTransferScratch).To(Equal(mdp.calledPhases[1]))
+	})
 })
 
 var _ = Describe("Convert", func() {
@@ -265,7 +316,7 @@ var _ = Describe("Convert", func() {
 		dp := NewDataProcessor(mdp, "dest", "dataDir", "scratchDataDir", "1G")
 		qemuOperations := NewFakeQEMUOperations(nil, nil, fakeInfoOpRetVal{&fakeZeroImageInfo, errors.New("Scratch space required, and none found ")}, nil, nil, nil)
 		replaceQEMUOperations(qemuOperations, func() {
-			nextPhase, err := dp.convert(mdp.GetURL())
+			nextPhase, err := dp.convert(context.Background(), mdp.GetURL())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(ProcessingPhaseResize).To(Equal(nextPhase))
 		})
@@ -280,7 +331,7 @@ var _ = Describe("Convert", func() {
 		dp := NewDataProcessor(mdp, "dest", "dataDir", "scratchDataDir", "1G")
 		qemuOperations := NewFakeQEMUOperations(nil, nil, fakeInfoOpRetVal{&fakeZeroImageInfo, errors.New("Scratch space required, and none found ")}, errors.New("Validation failure"), nil, nil)
 		replaceQEMUOperations(qemuOperations, func() {
-			nextPhase, err := dp.convert(mdp.GetURL())
+			nextPhase, err := dp.convert(context.Background(), mdp.GetURL())
 			Expect(err).To(HaveOccurred())
 			Expect(ProcessingPhaseError).To(Equal(nextPhase))
 		})
@@ -295,8 +346,45 @@ var _ = Describe("Convert", func() {
 		dp := NewDataProcessor(mdp, "dest", "dataDir", "scratchDataDir", "1G")
 		qemuOperations := NewFakeQEMUOperations(errors.New("Conversion failure"), nil, fakeInfoOpRetVal{&fakeZeroImageInfo, errors.New("Scratch space required, and none found ")}, nil, nil, nil)
 		replaceQEMUOperations(qemuOperations, func() {
-			nextPhase, err := dp.convert(mdp.GetURL())
+			nextPhase, err := dp.convert(context.Background(), mdp.GetURL())
+			Expect(err).To(HaveOccurred())
+			Expect(ProcessingPhaseError).To(Equal(nextPhase))
+		})
+	})
+
+	It("Should report the wrapped validation error to the observer, not just the sentinel", func() {
+		url, err := url.Parse("http://fakeurl-notreal.fake")
+		Expect(err).ToNot(HaveOccurred())
+		mdp := &MockDataProvider{
+			url: url,
+		}
+		observer := &recordingObserver{}
+		dp := NewDataProcessorWithObserver(mdp, "dest", "dataDir", "scratchDataDir", "1G", PhaseTimeouts{}, observer)
+		validationErr := errors.New("Validation failure")
+		qemuOperations := NewFakeQEMUOperations(nil, nil, fakeInfoOpRetVal{&fakeZeroImageInfo, nil}, validationErr, nil, nil)
+		replaceQEMUOperations(qemuOperations, func() {
+			_, err := dp.observe(ProcessingPhaseConvert, func() (ProcessingPhase, error) {
+				return dp.convert(context.Background(), mdp.GetURL())
+			})
 			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(Equal(validationErr))
+			Expect(errors.Cause(err)).To(Equal(validationErr))
+			Expect(observer.ends).To(HaveLen(1))
+			Expect(observer.ends[0].err).To(Equal(err))
+		})
+	})
+
+	It("Should abort a conversion whose deadline has elapsed", func() {
+		url, err := url.Parse("http://fakeurl-notreal.fake")
+		Expect(err).ToNot(HaveOccurred())
+		mdp := &MockDataProvider{
+			url: url,
+		}
+		dp := NewDataProcessorWithTimeouts(mdp, "dest", "dataDir", "scratchDataDir", "1G", PhaseTimeouts{Convert: time.Nanosecond})
+		qemuOperations := NewFakeQEMUOperations(context.DeadlineExceeded, nil, fakeInfoRet, nil, nil, nil)
+		replaceQEMUOperations(qemuOperations, func() {
+			nextPhase, err := dp.convert(context.Background(), mdp.GetURL())
+			Expect(err).To(Equal(ErrPhaseTimeout))
 			Expect(ProcessingPhaseError).To(Equal(nextPhase))
 		})
 	})
@@ -310,7 +398,7 @@ var _ = Describe("Resize", func() {
 			url: url,
 		}
 		dp := NewDataProcessor(mdp, "dest", "dataDir", "scratchDataDir", "")
-		nextPhase, err := dp.resize()
+		nextPhase, err := dp.resize(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(ProcessingPhaseComplete).To(Equal(nextPhase))
 	})
@@ -326,7 +414,7 @@ var _ = Describe("Resize", func() {
 				url: url,
 			}
 			dp := NewDataProcessor(mdp, "dest", "dataDir", "scratchDataDir", "1G")
-			nextPhase, err := dp.resize()
+			nextPhase, err := dp.resize(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(ProcessingPhaseComplete).To(Equal(nextPhase))
 		})
@@ -343,7 +431,7 @@ var _ = Describe("Resize", func() {
 		dp := NewDataProcessor(mdp, "dest", tmpDir, "scratchDataDir", "1G")
 		qemuOperations := NewFakeQEMUOperations(nil, nil, fakeInfoOpRetVal{&fakeZeroImageInfo, nil}, nil, nil, nil)
 		replaceQEMUOperations(qemuOperations, func() {
-			nextPhase, err := dp.resize()
+			nextPhase, err := dp.resize(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(ProcessingPhaseComplete).To(Equal(nextPhase))
 		})
@@ -360,18 +448,42 @@ var _ = Describe("Resize", func() {
 		dp := NewDataProcessor(mdp, "dest", tmpDir, "scratchDataDir", "1G")
 		qemuOperations := NewQEMUAllErrors()
 		replaceQEMUOperations(qemuOperations, func() {
-			nextPhase, err := dp.resize()
+			nextPhase, err := dp.resize(context.Background())
 			Expect(err).To(HaveOccurred())
 			Expect(ProcessingPhaseError).To(Equal(nextPhase))
 		})
 	})
+
+	It("Should report ProcessingPhaseResize to the observer with a non-zero duration", func() {
+		tmpDir, err := ioutil.TempDir("", "data")
+		Expect(err).ToNot(HaveOccurred())
+		url, err := url.Parse("http://fakeurl-notreal.fake")
+		Expect(err).ToNot(HaveOccurred())
+		mdp := &MockDataProvider{
+			url: url,
+		}
+		observer := &recordingObserver{}
+		dp := NewDataProcessorWithObserver(mdp, "dest", tmpDir, "scratchDataDir", "1G", PhaseTimeouts{}, observer)
+		qemuOperations := NewFakeQEMUOperations(nil, nil, fakeInfoOpRetVal{&fakeZeroImageInfo, nil}, nil, nil, nil)
+		replaceQEMUOperations(qemuOperations, func() {
+			const minDuration = 5 * time.Millisecond
+			_, err := dp.observe(ProcessingPhaseResize, func() (ProcessingPhase, error) {
+				time.Sleep(minDuration)
+				return dp.resize(context.Background())
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(observer.ends).To(HaveLen(1))
+			Expect(observer.ends[0].phase).To(Equal(ProcessingPhaseResize))
+			Expect(observer.ends[0].duration).To(BeNumerically(">=", minDuration))
+		})
+	})
 })
 
 var _ = Describe("ResizeImage", func() {
 	//fakeInfoRet has info.VirtualSize=1024
 	table.DescribeTable("calling ResizeImage", func(qemuOperations image.QEMUOperations, imageSize string, totalSpace int64, wantErr bool) {
 		replaceQEMUOperations(qemuOperations, func() {
-			err := ResizeImage("dest", imageSize, totalSpace)
+			err := ResizeImage(context.Background(), "dest", imageSize, totalSpace)
 			if !wantErr {
 				Expect(err).ToNot(HaveOccurred())
 			} else {
@@ -400,26 +512,26 @@ func NewFakeQEMUOperations(e2, e3 error, ret4 fakeInfoOpRetVal, e5 error, e6 err
 	return &fakeQEMUOperations{e2, e3, ret4, e5, e6, targetResize}
 }
 
-func (o *fakeQEMUOperations) ConvertToRawStream(*url.URL, string) error {
+func (o *fakeQEMUOperations) ConvertToRawStream(ctx context.Context, url *url.URL, dest string) error {
 	return o.e2
 }
 
-func (o *fakeQEMUOperations) Validate(*url.URL, int64) error {
+func (o *fakeQEMUOperations) Validate(ctx context.Context, url *url.URL, size int64) error {
 	return o.e5
 }
 
-func (o *fakeQEMUOperations) Resize(dest string, size resource.Quantity) error {
+func (o *fakeQEMUOperations) Resize(ctx context.Context, dest string, size resource.Quantity) error {
 	if o.resizeQuantity != nil {
 		Expect(o.resizeQuantity.Cmp(size)).To(Equal(0))
 	}
 	return o.e3
 }
 
-func (o *fakeQEMUOperations) Info(url *url.URL) (*image.ImgInfo, error) {
+func (o *fakeQEMUOperations) Info(ctx context.Context, url *url.URL) (*image.ImgInfo, error) {
 	return o.ret4.imgInfo, o.ret4.e
 }
 
-func (o *fakeQEMUOperations) CreateBlankImage(dest string, size resource.Quantity) error {
+func (o *fakeQEMUOperations) CreateBlankImage(ctx context.Context, dest string, size resource.Quantity) error {
 	return o.e6
 }
 
@@ -0,0 +1,57 @@
+package importer
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	phaseDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "cdi_import_phase_duration_seconds",
+		Help: "Duration of each DataProcessor phase, in seconds.",
+	}, []string{"phase"})
+
+	bytesTransferredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cdi_import_bytes_total",
+		Help: "Cumulative number of bytes transferred by the importer.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(phaseDurationSeconds, bytesTransferredTotal)
+}
+
+// PrometheusPhaseObserver records phase durations in cdi_import_phase_duration_seconds and
+// transferred bytes in cdi_import_bytes_total.
+type PrometheusPhaseObserver struct {
+	lastBytes int64
+}
+
+// NewPrometheusPhaseObserver returns a PhaseObserver that updates the cdi_import_* metrics.
+func NewPrometheusPhaseObserver() *PrometheusPhaseObserver {
+	return &PrometheusPhaseObserver{}
+}
+
+// OnPhaseStart resets lastBytes when a new chunked transfer begins. A BatchDataProvider reuses one
+// PhaseObserver across every entry in a manifest, so without this reset the second entry's offsets
+// start back near 0 relative to the first entry's, which looks like a negative delta and gets
+// silently dropped by the delta > 0 guard in OnBytes, permanently undercounting the total.
+func (o *PrometheusPhaseObserver) OnPhaseStart(phase ProcessingPhase) {
+	if phase == ProcessingPhaseTransferChunked {
+		o.lastBytes = 0
+	}
+}
+
+// OnPhaseEnd records phase's duration, regardless of whether it succeeded.
+func (o *PrometheusPhaseObserver) OnPhaseEnd(phase ProcessingPhase, err error, duration time.Duration) {
+	phaseDurationSeconds.WithLabelValues(string(phase)).Observe(duration.Seconds())
+}
+
+// OnBytes adds the bytes transferred since the previous call to cdi_import_bytes_total.
+func (o *PrometheusPhaseObserver) OnBytes(transferred, total int64) {
+	if delta := transferred - o.lastBytes; delta > 0 {
+		bytesTransferredTotal.Add(float64(delta))
+	}
+	o.lastBytes = transferred
+}
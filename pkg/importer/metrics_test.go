@@ -0,0 +1,47 @@
+package importer
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("PrometheusPhaseObserver", func() {
+	It("records the full cumulative delta across every entry in a batch, not just the last", func() {
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		scratch1, err := ioutil.TempDir("", "scratch1")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratch1)
+		scratch2, err := ioutil.TempDir("", "scratch2")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratch2)
+
+		first := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseTransferChunked, processResponse: ProcessingPhaseComplete},
+			total:            100,
+		}
+		second := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL, infoResponse: ProcessingPhaseTransferChunked, processResponse: ProcessingPhaseComplete},
+			total:            60,
+		}
+
+		observer := NewPrometheusPhaseObserver()
+		before := testutil.ToFloat64(bytesTransferredTotal)
+
+		b := NewBatchDataProvider([]BatchEntry{
+			{Provider: first, Dest: "dest1", DataDir: "dataDir1", ScratchDataDir: scratch1},
+			{Provider: second, Dest: "dest2", DataDir: "dataDir2", ScratchDataDir: scratch2},
+		}, PhaseTimeouts{}, observer)
+
+		Expect(b.ProcessAll(context.Background())).To(Succeed())
+		Expect(testutil.ToFloat64(bytesTransferredTotal) - before).To(Equal(float64(first.total + second.total)))
+	})
+})
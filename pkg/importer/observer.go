@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"io"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// PhaseObserver receives structured notifications as DataProcessor moves through its phase state
+// machine, for logging, metrics, or test assertions that need more than the final error.
+type PhaseObserver interface {
+	// OnPhaseStart is called immediately before phase runs.
+	OnPhaseStart(phase ProcessingPhase)
+	// OnPhaseEnd is called after phase finishes, with the error it returned (nil on success) and
+	// how long it took.
+	OnPhaseEnd(phase ProcessingPhase, err error, duration time.Duration)
+	// OnBytes is called as a transfer progresses, with the cumulative bytes transferred so far
+	// and the total if known (0 if not).
+	OnBytes(transferred, total int64)
+}
+
+// noopObserver is the default PhaseObserver: NewDataProcessor and NewDataProcessorWithTimeouts
+// use it so callers that don't care about observability pay nothing for it.
+type noopObserver struct{}
+
+func (noopObserver) OnPhaseStart(ProcessingPhase)                     {}
+func (noopObserver) OnPhaseEnd(ProcessingPhase, error, time.Duration) {}
+func (noopObserver) OnBytes(int64, int64)                             {}
+
+// observe runs fn, reporting its start and end to dp.observer.
+func (dp *DataProcessor) observe(phase ProcessingPhase, fn func() (ProcessingPhase, error)) (ProcessingPhase, error) {
+	dp.observer.OnPhaseStart(phase)
+	start := time.Now()
+	next, err := fn()
+	dp.observer.OnPhaseEnd(phase, err, time.Since(start))
+	return next, err
+}
+
+// Observer returns the PhaseObserver dp was constructed with, so a DataProvider can report
+// transfer progress through the same channel as phase events.
+func (dp *DataProcessor) Observer() PhaseObserver {
+	return dp.observer
+}
+
+// LoggingPhaseObserver reports phase transitions and transfer progress as structured klog lines.
+type LoggingPhaseObserver struct{}
+
+// NewLoggingPhaseObserver returns a PhaseObserver that logs every phase and progress update.
+func NewLoggingPhaseObserver() *LoggingPhaseObserver {
+	return &LoggingPhaseObserver{}
+}
+
+// OnPhaseStart logs that phase is starting.
+func (o *LoggingPhaseObserver) OnPhaseStart(phase ProcessingPhase) {
+	klog.V(1).Infof("import phase %s started", phase)
+}
+
+// OnPhaseEnd logs phase's outcome and duration.
+func (o *LoggingPhaseObserver) OnPhaseEnd(phase ProcessingPhase, err error, duration time.Duration) {
+	if err != nil {
+		klog.Errorf("import phase %s failed after %s: %v", phase, duration, err)
+		return
+	}
+	klog.V(1).Infof("import phase %s finished in %s", phase, duration)
+}
+
+// OnBytes logs the current transfer progress.
+func (o *LoggingPhaseObserver) OnBytes(transferred, total int64) {
+	if total > 0 {
+		klog.V(1).Infof("transferred %d/%d bytes", transferred, total)
+		return
+	}
+	klog.V(1).Infof("transferred %d bytes", transferred)
+}
+
+// ProgressReader wraps an io.Reader, reporting cumulative bytes read to onProgress at roughly
+// interval granularity (and always on the read that returns an error, e.g. io.EOF). No
+// DataProvider in this package wraps its reader in a ProgressReader; the only OnBytes calls the
+// DataProcessor itself makes come from the chunked transfer phase, once per committed chunk.
+type ProgressReader struct {
+	io.Reader
+	total      int64
+	interval   time.Duration
+	onProgress func(transferred, total int64)
+	read       int64
+	lastReport time.Time
+}
+
+// NewProgressReader wraps r, calling onProgress with the cumulative bytes read and total (0 if
+// unknown) on the first read, then no more often than interval, except that a read returning an
+// error always reports.
+func NewProgressReader(r io.Reader, total int64, interval time.Duration, onProgress func(transferred, total int64)) *ProgressReader {
+	return &ProgressReader{Reader: r, total: total, interval: interval, onProgress: onProgress}
+}
+
+// Read implements io.Reader, reporting progress as data is read.
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	p.read += int64(n)
+	if p.onProgress != nil && (p.lastReport.IsZero() || err != nil || time.Since(p.lastReport) >= p.interval) {
+		p.onProgress(p.read, p.total)
+		p.lastReport = time.Now()
+	}
+	return n, err
+}
@@ -0,0 +1,41 @@
+package importer
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProgressReader", func() {
+	It("reports immediately, then again only once the interval elapses, then always on error", func() {
+		var reports [][2]int64
+		r := NewProgressReader(strings.NewReader("hello world"), 11, time.Hour, func(transferred, total int64) {
+			reports = append(reports, [2]int64{transferred, total})
+		})
+
+		buf := make([]byte, 5)
+		n, err := r.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(5))
+		Expect(reports).To(Equal([][2]int64{{5, 11}}))
+
+		// interval is an hour, so a second read shouldn't report again yet.
+		n, err = r.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(5))
+		Expect(reports).To(HaveLen(1))
+
+		// the third read drains the last byte but doesn't hit EOF yet (io.Reader convention).
+		n, err = r.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(1))
+		Expect(reports).To(HaveLen(1))
+
+		// the fourth read hits EOF, which should always report regardless of interval.
+		_, err = r.Read(buf)
+		Expect(err).To(HaveOccurred())
+		Expect(reports).To(Equal([][2]int64{{5, 11}, {11, 11}}))
+	})
+})
@@ -0,0 +1,129 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ResumableDataProvider is a DataProvider that can report its total size up front and transfer it
+// in arbitrary byte ranges, so a killed and restarted import can pick up where it left off
+// instead of re-downloading from the start.
+type ResumableDataProvider interface {
+	DataProvider
+	// Size returns the total number of bytes the source will transfer.
+	Size(ctx context.Context) (int64, error)
+	// TransferRange transfers the byte range [offset, offset+length) of the source into path. It
+	// returns ProcessingPhaseTransferChunked while more ranges remain, or the next phase once the
+	// final range has been committed.
+	TransferRange(ctx context.Context, path string, offset, length int64) (ProcessingPhase, error)
+}
+
+// transferProgressFileName is written under scratchDataDir after every committed chunk, so a
+// restarted import can resume instead of starting over.
+const transferProgressFileName = ".transfer-progress.json"
+
+// transferProgress is the on-disk record of how far a chunked transfer has gotten.
+type transferProgress struct {
+	// Offset is the number of bytes already committed to dataDir.
+	Offset int64 `json:"offset"`
+	// Checksum is the source-reported digest of the bytes transferred so far, if any.
+	Checksum string `json:"checksum,omitempty"`
+	// SourceURL is recorded so a resume can detect a stale progress file left over from a
+	// different source.
+	SourceURL string `json:"sourceUrl"`
+}
+
+func progressFilePath(scratchDataDir string) string {
+	return filepath.Join(scratchDataDir, transferProgressFileName)
+}
+
+// loadTransferProgress returns the persisted transferProgress for scratchDataDir, or nil if no
+// transfer has been checkpointed there yet.
+func loadTransferProgress(scratchDataDir string) (*transferProgress, error) {
+	data, err := ioutil.ReadFile(progressFilePath(scratchDataDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to read transfer progress")
+	}
+	progress := &transferProgress{}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, errors.Wrap(err, "unable to parse transfer progress")
+	}
+	return progress, nil
+}
+
+// saveTransferProgress persists progress under scratchDataDir, overwriting any previous record.
+func saveTransferProgress(scratchDataDir string, progress *transferProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal transfer progress")
+	}
+	return ioutil.WriteFile(progressFilePath(scratchDataDir), data, 0644)
+}
+
+// transferChunked drives a ResumableDataProvider through TransferRange calls sized at
+// dp.chunkSize, checkpointing progress after each one, until the provider reports the transfer is
+// done. It resumes from the last checkpointed offset found under scratchDataDir, if any.
+func (dp *DataProcessor) transferChunked(ctx context.Context) (ProcessingPhase, error) {
+	resumable, ok := dp.dataProvider.(ResumableDataProvider)
+	if !ok {
+		return ProcessingPhaseError, errors.New("data provider does not support chunked transfer")
+	}
+
+	total, err := resumable.Size(ctx)
+	if err != nil {
+		return ProcessingPhaseError, err
+	}
+
+	offset := int64(0)
+	if progress, err := loadTransferProgress(dp.scratchDataDir); err != nil {
+		return ProcessingPhaseError, err
+	} else if progress != nil && progress.SourceURL == dp.dataProvider.GetURL().String() {
+		offset = progress.Offset
+	}
+
+	chunkSize := dp.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	// offset can already be >= total on entry: a zero-byte source, or a resume whose checkpoint was
+	// written for the final chunk but the process was killed before the phase transitioned. Default
+	// to the phase after a completed transfer so the loop below, which never runs in that case,
+	// doesn't leave the state machine stuck re-entering this same phase forever.
+	next := ProcessingPhaseProcess
+	for offset < total {
+		length := chunkSize
+		if remaining := total - offset; remaining < length {
+			length = remaining
+		}
+
+		next, err = dp.withTimeout(ctx, dp.timeouts.Transfer, func(ctx context.Context) (ProcessingPhase, error) {
+			return resumable.TransferRange(ctx, dp.dataDir, offset, length)
+		})
+		if err != nil {
+			return ProcessingPhaseError, err
+		}
+
+		offset += length
+		if err := saveTransferProgress(dp.scratchDataDir, &transferProgress{
+			Offset:    offset,
+			SourceURL: dp.dataProvider.GetURL().String(),
+		}); err != nil {
+			return ProcessingPhaseError, err
+		}
+		dp.observer.OnBytes(offset, total)
+
+		if next != ProcessingPhaseTransferChunked {
+			break
+		}
+	}
+	return next, nil
+}
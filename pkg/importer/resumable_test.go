@@ -0,0 +1,194 @@
+package importer
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+)
+
+// fakeResumableProvider is a ResumableDataProvider whose TransferRange can be made to fail after
+// a fixed number of calls, to simulate a process killed mid-transfer.
+type fakeResumableProvider struct {
+	MockDataProvider
+	total      int64
+	rangeCalls []int64
+	failAfter  int
+}
+
+func (f *fakeResumableProvider) Size(ctx context.Context) (int64, error) {
+	return f.total, nil
+}
+
+func (f *fakeResumableProvider) TransferRange(ctx context.Context, path string, offset, length int64) (ProcessingPhase, error) {
+	f.rangeCalls = append(f.rangeCalls, offset)
+	if f.failAfter > 0 && len(f.rangeCalls) > f.failAfter {
+		return ProcessingPhaseError, errors.New("simulated crash mid-transfer")
+	}
+	if offset+length >= f.total {
+		return ProcessingPhaseProcess, nil
+	}
+	return ProcessingPhaseTransferChunked, nil
+}
+
+// recordingBytesObserver is a PhaseObserver that only cares about OnBytes, recording every
+// transferred/total pair it sees.
+type recordingBytesObserver struct {
+	transferred []int64
+	total       int64
+}
+
+func (o *recordingBytesObserver) OnPhaseStart(ProcessingPhase) {}
+func (o *recordingBytesObserver) OnPhaseEnd(ProcessingPhase, error, time.Duration) {}
+func (o *recordingBytesObserver) OnBytes(transferred, total int64) {
+	o.transferred = append(o.transferred, transferred)
+	o.total = total
+}
+
+var _ = Describe("Resumable transfer", func() {
+	It("resumes TransferRange from the last checkpointed offset after a simulated crash", func() {
+		scratchDir, err := ioutil.TempDir("", "scratch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratchDir)
+
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		crashing := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            100,
+			failAfter:        1,
+		}
+		dp := NewDataProcessor(crashing, "dest", "dataDir", scratchDir, "")
+		dp.chunkSize = 40
+
+		_, err = dp.transferChunked(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(crashing.rangeCalls).To(Equal([]int64{0, 40}))
+
+		progress, err := loadTransferProgress(scratchDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress).ToNot(BeNil())
+		Expect(progress.Offset).To(Equal(int64(40)))
+
+		resumed := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            100,
+		}
+		dp2 := NewDataProcessor(resumed, "dest", "dataDir", scratchDir, "")
+		dp2.chunkSize = 40
+
+		nextPhase, err := dp2.transferChunked(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ProcessingPhaseProcess).To(Equal(nextPhase))
+		Expect(resumed.rangeCalls).To(Equal([]int64{40, 80}))
+	})
+
+	It("starts from offset zero when no progress file exists", func() {
+		scratchDir, err := ioutil.TempDir("", "scratch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratchDir)
+
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		provider := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            40,
+		}
+		dp := NewDataProcessor(provider, "dest", "dataDir", scratchDir, "")
+		dp.chunkSize = 40
+
+		nextPhase, err := dp.transferChunked(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ProcessingPhaseProcess).To(Equal(nextPhase))
+		Expect(provider.rangeCalls).To(Equal([]int64{0}))
+	})
+
+	It("reports cumulative bytes transferred to the observer after every chunk", func() {
+		scratchDir, err := ioutil.TempDir("", "scratch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratchDir)
+
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		provider := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            100,
+		}
+		observer := &recordingBytesObserver{}
+		dp := NewDataProcessorWithObserver(provider, "dest", "dataDir", scratchDir, "", PhaseTimeouts{}, observer)
+		dp.chunkSize = 40
+
+		_, err = dp.transferChunked(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(observer.transferred).To(Equal([]int64{40, 80, 100}))
+		Expect(observer.total).To(Equal(int64(100)))
+	})
+
+	It("does not loop forever when the checkpointed offset already covers the whole source", func() {
+		scratchDir, err := ioutil.TempDir("", "scratch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratchDir)
+
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(saveTransferProgress(scratchDir, &transferProgress{
+			Offset:    100,
+			SourceURL: srcURL.String(),
+		})).To(Succeed())
+
+		provider := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            100,
+		}
+		dp := NewDataProcessor(provider, "dest", "dataDir", scratchDir, "")
+		dp.chunkSize = 40
+
+		done := make(chan struct{})
+		var nextPhase ProcessingPhase
+		go func() {
+			nextPhase, err = dp.transferChunked(context.Background())
+			close(done)
+		}()
+		Eventually(done, "1s").Should(BeClosed())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ProcessingPhaseProcess).To(Equal(nextPhase))
+		Expect(provider.rangeCalls).To(BeEmpty())
+	})
+
+	It("does not loop forever on a zero-byte source", func() {
+		scratchDir, err := ioutil.TempDir("", "scratch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(scratchDir)
+
+		srcURL, err := url.Parse("http://fakeurl-notreal.fake/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+
+		provider := &fakeResumableProvider{
+			MockDataProvider: MockDataProvider{url: srcURL},
+			total:            0,
+		}
+		dp := NewDataProcessor(provider, "dest", "dataDir", scratchDir, "")
+		dp.chunkSize = 40
+
+		done := make(chan struct{})
+		var nextPhase ProcessingPhase
+		go func() {
+			nextPhase, err = dp.transferChunked(context.Background())
+			close(done)
+		}()
+		Eventually(done, "1s").Should(BeClosed())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ProcessingPhaseProcess).To(Equal(nextPhase))
+		Expect(provider.rangeCalls).To(BeEmpty())
+	})
+})